@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 var (
@@ -22,24 +23,26 @@ type SimulatorAPI struct{}
 
 //SimulatorDataMessage comment
 type SimulatorDataMessage struct {
-	MessageID int
-	CarID     int
-	Timestamp string
-	Accuracy  int
-	Lat       float32
-	Lon       float32
+	MessageID   int
+	CarID       int
+	Timestamp   string
+	Accuracy    int
+	Lat         float32
+	Lon         float32
+	VehicleType string
 }
 
 //PushData : post mockup simulation data via rest API
 func (s *SimulatorAPI) PushData(w http.ResponseWriter, r *http.Request) {
 	log.Print("Received SimulatorApi.PushData API request")
 	msgData := SimulatorDataMessage{
-		MessageID: 1,
-		CarID:     2,
-		Timestamp: "yyyy-mm-dd hh:MM:ss",
-		Accuracy:  3,
-		Lat:       13.296343,
-		Lon:       52.528917,
+		MessageID:   1,
+		CarID:       2,
+		Timestamp:   "yyyy-mm-dd hh:MM:ss",
+		Accuracy:    3,
+		Lat:         13.296343,
+		Lon:         52.528917,
+		VehicleType: "car",
 	}
 
 	msgDataJSON, err := json.Marshal(msgData)
@@ -74,5 +77,17 @@ func main() {
 	globalNatsConn = nc
 
 	http.HandleFunc("/simulator", new(SimulatorAPI).PushData)
+
+	if pgURI != "" {
+		if timeout, err := time.ParseDuration(os.Getenv("DB_QUERY_TIMEOUT")); err == nil {
+			dbQueryTimeout = timeout
+		}
+		zoneAPI, err := newZoneAPI(pgURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.HandleFunc("/zones", zoneAPI.Zones)
+		http.HandleFunc("/zones/", zoneAPI.Zone)
+	}
 	http.ListenAndServe(":80", nil)
 }
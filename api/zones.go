@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	pgURI                 = os.Getenv("PG_URI") // zones are only managed with a database
+	zonesChangedQueueName = "zones.changed"
+	dbQueryTimeout        = 5 * time.Second
+)
+
+//errZoneNotFound is answered with 404 for ids that are malformed or not in the database
+var errZoneNotFound = errors.New("zone not found")
+
+const zoneColumns = `id, name, pollution, to_char(valid_from, 'YYYY-MM-DD'), to_char(valid_until, 'YYYY-MM-DD'), ST_AsGeoJSON(outline)`
+
+//ZoneAPI : Used to manage the pollution zones
+type ZoneAPI struct {
+	db *sql.DB
+}
+
+//ZoneFeature is a pollution zone as geojson feature
+type ZoneFeature struct {
+	Type       string          `json:"type"`
+	ID         int64           `json:"id,omitempty"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties ZoneProperties  `json:"properties"`
+}
+
+//ZoneProperties of a pollution zone, it only counts from valid from to valid until if they are set
+type ZoneProperties struct {
+	Name       string `json:"name"`
+	Pollution  int    `json:"pollution"`
+	ValidFrom  string `json:"validFrom,omitempty"`  // YYYY-MM-DD
+	ValidUntil string `json:"validUntil,omitempty"` // YYYY-MM-DD, inclusive
+}
+
+//ZoneFeatureCollection of all pollution zones
+type ZoneFeatureCollection struct {
+	Type     string        `json:"type"`
+	Features []ZoneFeature `json:"features"`
+}
+
+//ZonesChangedMessageData tells caches of the zones to reload
+type ZonesChangedMessageData struct {
+	ZoneID    int64  `json:"zoneId"`
+	Change    string `json:"change"` // created, updated or deleted
+	Timestamp string `json:"timestamp"`
+	Sender    string `json:"sender"`
+	Topic     string `json:"topic"`
+}
+
+//ZonesChangedMessage published on zones.changed
+type ZonesChangedMessage struct {
+	Data ZonesChangedMessageData `json:"data"`
+}
+
+//newZoneAPI connects to postgis, the tables are created by schema/zones.sql
+func newZoneAPI(uri string) (*ZoneAPI, error) {
+	db, err := sql.Open("postgres", uri)
+	if err != nil {
+		return nil, err
+	}
+	return &ZoneAPI{db: db}, nil
+}
+
+//Zones : list all zones as feature collection or create a zone from a posted feature
+func (z *ZoneAPI) Zones(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received ZoneAPI.Zones %s API request", r.Method)
+	ctx, cancel := context.WithTimeout(r.Context(), dbQueryTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := z.db.QueryContext(ctx, "SELECT "+zoneColumns+" FROM berlin_polygons ORDER BY id")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer rows.Close()
+		collection := ZoneFeatureCollection{Type: "FeatureCollection", Features: []ZoneFeature{}}
+		for rows.Next() {
+			feature, err := scanZoneFeature(rows)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			collection.Features = append(collection.Features, feature)
+		}
+		if err := rows.Err(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, collection)
+	case http.MethodPost:
+		feature, status, err := z.readZoneFeature(ctx, r)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		properties := feature.Properties
+		err = z.db.QueryRowContext(ctx, `INSERT INTO berlin_polygons (name, pollution, valid_from, valid_until, outline)
+			VALUES ($1, $2, $3::date, $4::date, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($5), 4326))) RETURNING id`,
+			properties.Name, properties.Pollution, nullString(properties.ValidFrom), nullString(properties.ValidUntil), string(feature.Geometry)).Scan(&feature.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		publishZonesChanged(feature.ID, "created")
+		writeJSON(w, http.StatusCreated, feature)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+//Zone : get, replace or delete the zone of /zones/{id}
+func (z *ZoneAPI) Zone(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received ZoneAPI.Zone %s API request", r.Method)
+	ctx, cancel := context.WithTimeout(r.Context(), dbQueryTimeout)
+	defer cancel()
+
+	id, err := zoneID(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		feature, err := scanZoneFeature(z.db.QueryRowContext(ctx, "SELECT "+zoneColumns+" FROM berlin_polygons WHERE id = $1", id))
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, errZoneNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, feature)
+	case http.MethodPut:
+		feature, status, err := z.readZoneFeature(ctx, r)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		feature.ID = id
+		properties := feature.Properties
+		result, err := z.db.ExecContext(ctx, `UPDATE berlin_polygons SET name = $2, pollution = $3, valid_from = $4::date, valid_until = $5::date,
+			outline = ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($6), 4326)) WHERE id = $1`,
+			id, properties.Name, properties.Pollution, nullString(properties.ValidFrom), nullString(properties.ValidUntil), string(feature.Geometry))
+		if status, err := changedRows(result, err); err != nil {
+			writeError(w, status, err)
+			return
+		}
+		publishZonesChanged(id, "updated")
+		writeJSON(w, http.StatusOK, feature)
+	case http.MethodDelete:
+		tx, err := z.db.BeginTx(ctx, nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "DELETE FROM zone_schedules WHERE zone_id = $1", id); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM berlin_polygons WHERE id = $1", id)
+		if status, err := changedRows(result, err); err != nil {
+			writeError(w, status, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		publishZonesChanged(id, "deleted")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+//zoneID returns the id of a /zones/{id} path
+func zoneID(path string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(path, "/zones/"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errZoneNotFound
+	}
+	return id, nil
+}
+
+//readZoneFeature decodes and validates the feature of the request body, status is the http status for the error
+func (z *ZoneAPI) readZoneFeature(ctx context.Context, r *http.Request) (feature ZoneFeature, status int, err error) {
+	if err := json.NewDecoder(r.Body).Decode(&feature); err != nil {
+		return feature, http.StatusBadRequest, err
+	}
+	feature.Type = "Feature"
+	if err := validateZoneFeature(feature); err != nil {
+		return feature, http.StatusBadRequest, err
+	}
+
+	//let postgis decide whether the polygon is valid, e.g. does not intersect itself
+	var validity string
+	err = z.db.QueryRowContext(ctx, `SELECT ST_IsValidReason(ST_GeomFromGeoJSON($1))`, string(feature.Geometry)).Scan(&validity)
+	if err != nil {
+		return feature, http.StatusBadRequest, fmt.Errorf("invalid geojson geometry: %v", err)
+	}
+	if validity != "Valid Geometry" {
+		return feature, http.StatusBadRequest, fmt.Errorf("invalid zone geometry: %s", validity)
+	}
+	return feature, http.StatusOK, nil
+}
+
+//validateZoneFeature checks the properties of the feature and that its geometry is a geojson polygon
+func validateZoneFeature(feature ZoneFeature) error {
+	properties := feature.Properties
+	if properties.Pollution < 1 || properties.Pollution > 9 {
+		return fmt.Errorf("pollution level %d is not between 1 and 9", properties.Pollution)
+	}
+	for _, date := range []string{properties.ValidFrom, properties.ValidUntil} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("validity %q is not a YYYY-MM-DD date", date)
+		}
+	}
+	if properties.ValidFrom != "" && properties.ValidUntil != "" && properties.ValidUntil < properties.ValidFrom {
+		return errors.New("zone is valid until before it is valid from")
+	}
+	if len(feature.Geometry) == 0 || string(feature.Geometry) == "null" {
+		return errors.New("zone has no geometry")
+	}
+
+	var geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(feature.Geometry, &geometry); err != nil {
+		return fmt.Errorf("invalid geojson geometry: %v", err)
+	}
+	var polygons [][][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &rings); err != nil {
+			return fmt.Errorf("invalid geojson polygon: %v", err)
+		}
+		polygons = append(polygons, rings)
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return fmt.Errorf("invalid geojson multi polygon: %v", err)
+		}
+	default:
+		return fmt.Errorf("zone geometry is a %q, not a polygon", geometry.Type)
+	}
+	if len(polygons) == 0 {
+		return errors.New("zone geometry is empty")
+	}
+	for _, rings := range polygons {
+		if len(rings) == 0 {
+			return errors.New("polygon has no outer ring")
+		}
+		for _, ring := range rings {
+			if err := validateRing(ring); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//validateRing checks that the ring is closed and its positions are [lon, lat] on the earth
+func validateRing(ring [][]float64) error {
+	if len(ring) < 4 {
+		return fmt.Errorf("ring has %d positions, a closed ring needs at least 4", len(ring))
+	}
+	for _, position := range ring {
+		if len(position) < 2 {
+			return fmt.Errorf("position %v has no longitude and latitude", position)
+		}
+		if position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+			return fmt.Errorf("position %v is not a longitude and latitude", position)
+		}
+	}
+	first, last := ring[0], ring[len(ring)-1]
+	if first[0] != last[0] || first[1] != last[1] {
+		return errors.New("ring is not closed, its last position has to repeat the first")
+	}
+	return nil
+}
+
+func scanZoneFeature(row interface {
+	Scan(dest ...interface{}) error
+}) (ZoneFeature, error) {
+	feature := ZoneFeature{Type: "Feature"}
+	var name, validFrom, validUntil sql.NullString
+	var geometry string
+	err := row.Scan(&feature.ID, &name, &feature.Properties.Pollution, &validFrom, &validUntil, &geometry)
+	feature.Properties.Name = name.String
+	feature.Properties.ValidFrom = validFrom.String
+	feature.Properties.ValidUntil = validUntil.String
+	feature.Geometry = json.RawMessage(geometry)
+	return feature, err
+}
+
+//changedRows turns a statement without affected rows into a not found error
+func changedRows(result sql.Result, err error) (int, error) {
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return http.StatusInternalServerError, err
+	} else if rows == 0 {
+		return http.StatusNotFound, errZoneNotFound
+	}
+	return http.StatusOK, nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	responseJSON, err := json.Marshal(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	log.Print(err)
+	writeJSON(w, status, map[string]string{"message": err.Error()})
+}
+
+func publishZonesChanged(zoneID int64, change string) {
+	msg := ZonesChangedMessage{
+		Data: ZonesChangedMessageData{
+			ZoneID:    zoneID,
+			Change:    change,
+			Timestamp: time.Now().Local().Format(time.RFC3339),
+			Sender:    "api",
+			Topic:     zonesChangedQueueName,
+		},
+	}
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	globalNatsConn.Publish(zonesChangedQueueName, msgJSON)
+	log.Printf("Published zone %d %s", zoneID, change)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSquare = `{"type":"Polygon","coordinates":[[[13.40,52.50],[13.42,52.50],[13.42,52.52],[13.40,52.52],[13.40,52.50]]]}`
+
+func TestValidateZoneFeature(t *testing.T) {
+	feature := func(pollution int, validFrom string, validUntil string, geometry string) ZoneFeature {
+		return ZoneFeature{
+			Type:       "Feature",
+			Geometry:   json.RawMessage(geometry),
+			Properties: ZoneProperties{Name: "Umweltzone", Pollution: pollution, ValidFrom: validFrom, ValidUntil: validUntil},
+		}
+	}
+	cases := []struct {
+		name    string
+		feature ZoneFeature
+		ok      bool
+	}{
+		{"polygon", feature(3, "", "", testSquare), true},
+		{"valid for one day", feature(3, "2026-05-04", "2026-05-04", testSquare), true},
+		{"multi polygon with a hole", feature(9, "2026-01-01", "", `{"type":"MultiPolygon","coordinates":[
+			[[[13.40,52.50],[13.42,52.50],[13.42,52.52],[13.40,52.52],[13.40,52.50]],[[13.405,52.505],[13.415,52.505],[13.415,52.515],[13.405,52.505]]],
+			[[[13.38,52.50],[13.39,52.50],[13.39,52.52],[13.38,52.50]]]]}`), true},
+		{"pollution level too low", feature(0, "", "", testSquare), false},
+		{"pollution level too high", feature(10, "", "", testSquare), false},
+		{"validity is not a date", feature(3, "04.05.2026", "", testSquare), false},
+		{"validity on a day that does not exist", feature(3, "", "2026-02-30", testSquare), false},
+		{"valid until before valid from", feature(3, "2026-06-01", "2026-05-31", testSquare), false},
+		{"no geometry", feature(3, "", "", ""), false},
+		{"null geometry", feature(3, "", "", "null"), false},
+		{"geometry is no geojson", feature(3, "", "", `"POLYGON((13.4 52.5,13.42 52.5,13.42 52.52,13.4 52.5))"`), false},
+		{"line string", feature(3, "", "", `{"type":"LineString","coordinates":[[13.40,52.50],[13.42,52.50]]}`), false},
+		{"polygon with point coordinates", feature(3, "", "", `{"type":"Polygon","coordinates":[13.40,52.50]}`), false},
+		{"polygon without rings", feature(3, "", "", `{"type":"Polygon","coordinates":[]}`), false},
+		{"empty multi polygon", feature(3, "", "", `{"type":"MultiPolygon","coordinates":[]}`), false},
+		{"ring not closed", feature(3, "", "", `{"type":"Polygon","coordinates":[[[13.40,52.50],[13.42,52.50],[13.42,52.52],[13.40,52.52]]]}`), false},
+		{"ring too short", feature(3, "", "", `{"type":"Polygon","coordinates":[[[13.40,52.50],[13.42,52.50],[13.40,52.50]]]}`), false},
+		{"position without latitude", feature(3, "", "", `{"type":"Polygon","coordinates":[[[13.40],[13.42,52.50],[13.42,52.52],[13.40]]]}`), false},
+		{"position off the earth", feature(3, "", "", `{"type":"Polygon","coordinates":[[[213.40,52.50],[13.42,52.50],[13.42,52.52],[213.40,52.50]]]}`), false},
+	}
+	for _, c := range cases {
+		if err := validateZoneFeature(c.feature); (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestZoneID(t *testing.T) {
+	cases := []struct {
+		path string
+		id   int64
+		ok   bool
+	}{
+		{"/zones/7", 7, true},
+		{"/zones/", 0, false},
+		{"/zones/abc", 0, false},
+		{"/zones/0", 0, false},
+		{"/zones/-3", 0, false},
+		{"/zones/7/schedules", 0, false},
+	}
+	for _, c := range cases {
+		id, err := zoneID(c.path)
+		if id != c.id || (err == nil) != c.ok {
+			t.Errorf("zoneID(%q) = %d, %v, want %d and ok %v", c.path, id, err, c.id, c.ok)
+		}
+	}
+}
+
+//the requests are answered before the database is queried, the api under test has none
+func TestZoneRequestsRejectedUpFront(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"get an unknown id", http.MethodGet, "/zones/abc", "", http.StatusNotFound},
+		{"replace an unknown id", http.MethodPut, "/zones/0", `{"type":"Feature"}`, http.StatusNotFound},
+		{"delete an unknown id", http.MethodDelete, "/zones/-1", "", http.StatusNotFound},
+		{"create from broken json", http.MethodPost, "/zones", `{"type":"Feature",`, http.StatusBadRequest},
+		{"create with a pollution level out of range", http.MethodPost, "/zones",
+			`{"type":"Feature","geometry":` + testSquare + `,"properties":{"name":"Umweltzone","pollution":12}}`, http.StatusBadRequest},
+		{"create with a bad validity", http.MethodPost, "/zones",
+			`{"type":"Feature","geometry":` + testSquare + `,"properties":{"name":"Umweltzone","pollution":3,"validFrom":"2026-06-01","validUntil":"2026-05-01"}}`, http.StatusBadRequest},
+		{"replace with a line", http.MethodPut, "/zones/3",
+			`{"type":"Feature","geometry":{"type":"LineString","coordinates":[[13.4,52.5],[13.5,52.5]]},"properties":{"name":"Umweltzone","pollution":3}}`, http.StatusBadRequest},
+		{"patch", http.MethodPatch, "/zones", "", http.StatusMethodNotAllowed},
+	}
+	api := &ZoneAPI{}
+	for _, c := range cases {
+		request := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		recorder := httptest.NewRecorder()
+		if c.path == "/zones" {
+			api.Zones(recorder, request)
+		} else {
+			api.Zone(recorder, request)
+		}
+		if recorder.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, recorder.Code, c.status)
+		}
+		var response map[string]string
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response["message"] == "" {
+			t.Errorf("%s: body %q has no error message", c.name, recorder.Body.String())
+		}
+	}
+}
+
+//affectedRows is the result of a statement which changed the number of rows
+type affectedRows int64
+
+func (r affectedRows) LastInsertId() (int64, error) { return 0, nil }
+func (r affectedRows) RowsAffected() (int64, error) { return int64(r), nil }
+
+func TestChangedRowsOfUnknownZone(t *testing.T) {
+	if status, err := changedRows(affectedRows(0), nil); status != http.StatusNotFound || err != errZoneNotFound {
+		t.Errorf("no changed rows: %d, %v, want 404", status, err)
+	}
+	if status, err := changedRows(affectedRows(1), nil); status != http.StatusOK || err != nil {
+		t.Errorf("one changed row: %d, %v, want 200", status, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+var (
+	rejectedQueueName  = "location.rejected"
+	maxAccuracy        = 50.0 // meters, less accurate points are rejected, 0 disables the check
+	maxSpeed           = 70.0 // m/s, points implying a faster movement are rejected, 0 disables the check
+	kalmanProcessNoise = 3.0  // m/s², expected acceleration of a car, 0 disables smoothing
+)
+
+//rejectReason returns why the point is not plausible after the previous accepted one, empty if it is
+func rejectReason(previous *SimulatorMessageData, point SimulatorMessageData) string {
+	if maxAccuracy > 0 && point.Accuracy > maxAccuracy {
+		return fmt.Sprintf("accuracy of %.0fm is worse than %.0fm", point.Accuracy, maxAccuracy)
+	}
+	if previous == nil || maxSpeed <= 0 {
+		return ""
+	}
+	from, to, err := pointTimes(*previous, point)
+	if err != nil {
+		return ""
+	}
+	seconds := to.Sub(from).Seconds()
+	if seconds <= 0 {
+		return "timestamp is not after the previous point"
+	}
+	if speed := Distance(previous.Lat, previous.Lon, point.Lat, point.Lon) / seconds; speed > maxSpeed {
+		return fmt.Sprintf("implied speed of %.0fkm/h is above %.0fkm/h", speed*3.6, maxSpeed*3.6)
+	}
+	return ""
+}
+
+//kalmanFilter smooths the positions of a car with a constant velocity model. East and north are filtered
+//independently in meters around the first point, the velocity changes by the process noise as acceleration
+//and every measurement is weighted by its reported accuracy. A car keeping its speed is not lagged behind.
+type kalmanFilter struct {
+	Origin *Coordinates // nil until the first point
+	East   kalmanAxis
+	North  kalmanAxis
+	Time   time.Time
+}
+
+//kalmanAxis is the state of one axis with its covariance
+type kalmanAxis struct {
+	Position         float64 // meters from the origin
+	Velocity         float64 // m/s
+	PositionVariance float64
+	Covariance       float64
+	VelocityVariance float64
+}
+
+//metersPerDegree of latitude, the filter works on the plane tangent at the origin
+const metersPerDegree = 111320.0
+
+//initialVelocityVariance lets the first measurements decide the velocity, (30 m/s)²
+const initialVelocityVariance = 900.0
+
+//predict moves the axis on by its velocity, the uncertainty grows by the acceleration noise
+func (a *kalmanAxis) predict(seconds float64, noise float64) {
+	q := noise * noise
+	a.Position += a.Velocity * seconds
+	a.PositionVariance += 2*seconds*a.Covariance + seconds*seconds*a.VelocityVariance + q*math.Pow(seconds, 4)/4
+	a.Covariance += seconds*a.VelocityVariance + q*math.Pow(seconds, 3)/2
+	a.VelocityVariance += q * seconds * seconds
+}
+
+//update corrects the axis by the measured position with the variance of its accuracy
+func (a *kalmanAxis) update(measured float64, variance float64) {
+	innovation := measured - a.Position
+	s := a.PositionVariance + variance
+	positionGain, velocityGain := a.PositionVariance/s, a.Covariance/s
+	a.Position += positionGain * innovation
+	a.Velocity += velocityGain * innovation
+	a.VelocityVariance -= velocityGain * a.Covariance
+	a.PositionVariance *= 1 - positionGain
+	a.Covariance *= 1 - positionGain
+}
+
+//smooth feeds the point into the filter and returns it with the smoothed position
+func (k *kalmanFilter) smooth(point SimulatorMessageData) SimulatorMessageData {
+	if kalmanProcessNoise <= 0 {
+		return point
+	}
+	accuracy := point.Accuracy
+	if accuracy < 1 {
+		accuracy = 1
+	}
+	timestamp, err := time.Parse(time.RFC3339, point.Timestamp)
+	if k.Origin == nil || err != nil {
+		k.Origin = nil
+		if err == nil {
+			k.Origin = &Coordinates{Lat: point.Lat, Lon: point.Lon}
+		}
+		k.East = kalmanAxis{PositionVariance: accuracy * accuracy, VelocityVariance: initialVelocityVariance}
+		k.North = k.East
+		k.Time = timestamp
+		return point
+	}
+
+	if seconds := timestamp.Sub(k.Time).Seconds(); seconds > 0 {
+		k.East.predict(seconds, kalmanProcessNoise)
+		k.North.predict(seconds, kalmanProcessNoise)
+		k.Time = timestamp
+	}
+	east, north := k.toMeters(point.Lat, point.Lon)
+	k.East.update(east, accuracy*accuracy)
+	k.North.update(north, accuracy*accuracy)
+
+	point.Lat, point.Lon = k.toDegrees(k.East.Position, k.North.Position)
+	return point
+}
+
+//toMeters projects the position onto the plane tangent at the origin
+func (k *kalmanFilter) toMeters(lat float64, lon float64) (float64, float64) {
+	return (lon - k.Origin.Lon) * metersPerDegree * math.Cos(k.Origin.Lat*math.Pi/180), (lat - k.Origin.Lat) * metersPerDegree
+}
+
+func (k *kalmanFilter) toDegrees(east float64, north float64) (float64, float64) {
+	return k.Origin.Lat + north/metersPerDegree, k.Origin.Lon + east/(metersPerDegree*math.Cos(k.Origin.Lat*math.Pi/180))
+}
+
+//rejection is a point dropped before matching together with the reason
+type rejection struct {
+	Point  SimulatorMessageData
+	Reason string
+}
+
+//RejectedMessageData is published for points which were dropped before matching
+type RejectedMessageData struct {
+	MessageID int                  `json:"messageId"`
+	CarID     string               `json:"carId"`
+	Timestamp string               `json:"timestamp"`
+	Point     SimulatorMessageData `json:"point"`
+	Reason    string               `json:"reason"`
+	Sender    string               `json:"sender"`
+	Topic     string               `json:"topic"`
+}
+
+//RejectedMessage published on location.rejected
+type RejectedMessage struct {
+	Data RejectedMessageData `json:"data"`
+}
+
+func (r RejectedMessage) toString() string {
+	return fmt.Sprintf("%+v\n", r)
+}
+
+func publishRejectedMessage(rejected rejection) {
+	msg := RejectedMessage{
+		Data: RejectedMessageData{
+			Sender:    "GoMicro-MapMatcher",
+			Topic:     rejectedQueueName,
+			MessageID: rejected.Point.MessageID,
+			CarID:     rejected.Point.CarID,
+			Timestamp: time.Now().Local().Format(time.RFC3339),
+			Point:     rejected.Point,
+			Reason:    rejected.Reason,
+		},
+	}
+
+	rejectedOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(rejectedQueueName, rejectedOutput)
+	logMessage(msg.Data.MessageID, "rejected")
+	fmt.Println("---published rejected message---\n" + msg.toString())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+//noise are measurement errors in meters within the reported accuracy of 10m
+var noise = []float64{6, -8, 3, -4, 9, -2, -7, 5, 1, -9, 4, 8, -5, -1, 7, -6, 2, -3, 10, -10}
+
+func TestKalmanFollowsMovingCar(t *testing.T) {
+	for _, interval := range []time.Duration{time.Second, 5 * time.Second} {
+		var filter kalmanFilter
+		var worst float64
+		speed := 14.0 // m/s north
+		for i, offset := range noise {
+			north := speed * interval.Seconds() * float64(i)
+			point := SimulatorMessageData{
+				Timestamp: testStart.Add(time.Duration(i) * interval).Format(time.RFC3339),
+				Accuracy:  10,
+				Lat:       52.5 + (north+offset)/metersPerDegree,
+				Lon:       13.4,
+			}
+			smoothed := filter.smooth(point)
+			//after the velocity settled the smoothed position must not trail the car
+			if i >= 5 {
+				lag := north - (smoothed.Lat-52.5)*metersPerDegree
+				worst = math.Max(worst, math.Abs(lag))
+			}
+		}
+		if worst > 15 {
+			t.Errorf("reports every %v: smoothed position off by up to %.0fm, want at most 15m", interval, worst)
+		}
+	}
+}
+
+func TestKalmanSmoothsStandingCar(t *testing.T) {
+	var filter kalmanFilter
+	var raw, smoothed float64
+	for i, offset := range noise {
+		point := filter.smooth(SimulatorMessageData{
+			Timestamp: testStart.Add(time.Duration(i) * 5 * time.Second).Format(time.RFC3339),
+			Accuracy:  10,
+			Lat:       52.5 + offset/metersPerDegree,
+			Lon:       13.4,
+		})
+		if i >= 5 {
+			raw += math.Abs(offset)
+			smoothed += math.Abs(point.Lat-52.5) * metersPerDegree
+		}
+	}
+	if smoothed >= raw {
+		t.Errorf("smoothed positions are %.0fm off in total, the reported ones %.0fm", smoothed, raw)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+//hmmMatcher is an in-process hidden markov model matcher after Newson and Krumm on a road graph
+//loaded from an osm extract. Candidates are the projections of a point onto the nearby edges,
+//the Viterbi algorithm picks the most likely sequence of candidates.
+type hmmMatcher struct {
+	graph         *roadGraph
+	sigma         float64 // standard deviation of the gps noise in meters
+	beta          float64 // expected difference between route and great circle distance in meters
+	radius        float64 // candidate search radius in meters
+	maxCandidates int
+}
+
+//hmmCandidate is a possible position of a point on an edge
+type hmmCandidate struct {
+	edge     int32
+	fraction float64
+	position Coordinates
+	distance float64 // meters between the reported point and its projection
+}
+
+//hmmStep is one point of a Viterbi chain with the best score of each candidate and its predecessor
+type hmmStep struct {
+	point      int
+	candidates []hmmCandidate
+	scores     []float64
+	back       []int
+}
+
+func newHMMMatcher(path string, vehicleType string) (*hmmMatcher, error) {
+	graph, err := loadRoadGraph(path, vehicleType)
+	if err != nil {
+		return nil, err
+	}
+	m := &hmmMatcher{
+		graph:         graph,
+		sigma:         10,
+		beta:          20,
+		radius:        matchRadiuses[0],
+		maxCandidates: 8,
+	}
+	if sigma, err := strconv.ParseFloat(os.Getenv("HMM_SIGMA"), 64); err == nil {
+		m.sigma = sigma
+	}
+	if beta, err := strconv.ParseFloat(os.Getenv("HMM_BETA"), 64); err == nil {
+		m.beta = beta
+	}
+	return m, nil
+}
+
+func (m *hmmMatcher) Match(points []SimulatorMessageData) (MatchedRoute, error) {
+	var route MatchedRoute
+	var chain []hmmStep
+	for i, point := range points {
+		candidates := m.candidates(point)
+		if len(candidates) == 0 {
+			route.Unmatched = append(route.Unmatched, point)
+			continue
+		}
+		if len(chain) > 0 {
+			if step, ok := m.transition(points, chain[len(chain)-1], i, candidates); ok {
+				chain = append(chain, step)
+				continue
+			}
+			//no candidate is reachable from the previous point, like osrm the trace is split into a new matching
+			route = m.appendChain(route, points, chain)
+		}
+		step := hmmStep{
+			point:      i,
+			candidates: candidates,
+			scores:     make([]float64, len(candidates)),
+			back:       make([]int, len(candidates)),
+		}
+		for k, candidate := range candidates {
+			step.scores[k] = m.emission(candidate)
+		}
+		chain = []hmmStep{step}
+	}
+	if len(chain) > 0 {
+		route = m.appendChain(route, points, chain)
+	}
+	if len(route.Route) == 0 {
+		return MatchedRoute{}, MatchError{Code: "NoMatch", Reason: "no road within the search radius"}
+	}
+
+	//share of the points which could be placed on the road network
+	route.Confidence = float64(len(points)-len(route.Unmatched)) / float64(len(points))
+	return route, nil
+}
+
+//Route interpolates the road driven between two points with the shortest path between their candidates
+func (m *hmmMatcher) Route(from SimulatorMessageData, to SimulatorMessageData) (MatchedRoute, error) {
+	sources, targets := m.candidates(from), m.candidates(to)
+	maxDistance := maxRouteDistance(Distance(from.Lat, from.Lon, to.Lat, to.Lon))
+	best := math.Inf(1)
+	var route MatchedRoute
+	for _, source := range sources {
+		distances, search := m.graph.shortestPaths(source, targets, maxDistance)
+		for k, distance := range distances {
+			if distance >= best {
+				continue
+			}
+			best = distance
+			path, roads := m.graph.pathTo(search, source, targets[k])
+			leg := RouteLeg{
+				Distance:     distance,
+				Roads:        roads,
+				Interpolated: true,
+				Start:        from.Timestamp,
+				End:          to.Timestamp,
+			}
+			for _, road := range roads {
+				leg.Duration += road.Duration
+			}
+			route = MatchedRoute{
+				Route:      append([]Coordinates{source.position}, path...),
+				Legs:       []RouteLeg{leg},
+				Distance:   leg.Distance,
+				Duration:   leg.Duration,
+				Confidence: 1,
+			}
+		}
+	}
+	if math.IsInf(best, 1) {
+		return MatchedRoute{}, MatchError{Code: "NoRoute", Reason: "no road path between the points"}
+	}
+	return route, nil
+}
+
+//candidates returns the closest projections of the point onto the edges within the search radius
+func (m *hmmMatcher) candidates(point SimulatorMessageData) []hmmCandidate {
+	var candidates []hmmCandidate
+	for _, edge := range m.graph.nearbyEdges(point.Lat, point.Lon, m.radius) {
+		position, fraction := m.graph.project(edge, point.Lat, point.Lon)
+		distance := Distance(point.Lat, point.Lon, position.Lat, position.Lon)
+		if distance > m.radius {
+			continue
+		}
+		candidates = append(candidates, hmmCandidate{
+			edge:     edge,
+			fraction: fraction,
+			position: position,
+			distance: distance,
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	if len(candidates) > m.maxCandidates {
+		candidates = candidates[:m.maxCandidates]
+	}
+	return candidates
+}
+
+//emission is the log likelihood of measuring the point if the car was at the candidate
+func (m *hmmMatcher) emission(candidate hmmCandidate) float64 {
+	return -0.5 * (candidate.distance / m.sigma) * (candidate.distance / m.sigma)
+}
+
+//transition advances the Viterbi chain to the candidates of point, ok is false if none is reachable
+func (m *hmmMatcher) transition(points []SimulatorMessageData, previous hmmStep, point int, candidates []hmmCandidate) (hmmStep, bool) {
+	from, to := points[previous.point], points[point]
+	straight := Distance(from.Lat, from.Lon, to.Lat, to.Lon)
+	step := hmmStep{
+		point:      point,
+		candidates: candidates,
+		scores:     make([]float64, len(candidates)),
+		back:       make([]int, len(candidates)),
+	}
+	for k := range step.scores {
+		step.scores[k] = math.Inf(-1)
+	}
+
+	ok := false
+	for j, source := range previous.candidates {
+		if math.IsInf(previous.scores[j], -1) {
+			continue
+		}
+		distances, _ := m.graph.shortestPaths(source, candidates, maxRouteDistance(straight))
+		for k, distance := range distances {
+			if math.IsInf(distance, 1) {
+				continue
+			}
+			score := previous.scores[j] - math.Abs(distance-straight)/m.beta + m.emission(candidates[k])
+			if score > step.scores[k] {
+				step.scores[k] = score
+				step.back[k] = j
+				ok = true
+			}
+		}
+	}
+	return step, ok
+}
+
+//appendChain backtracks the most likely candidates of the chain and appends their road path to the route
+func (m *hmmMatcher) appendChain(route MatchedRoute, points []SimulatorMessageData, chain []hmmStep) MatchedRoute {
+	last := chain[len(chain)-1]
+	best := 0
+	for k, score := range last.scores {
+		if score > last.scores[best] {
+			best = k
+		}
+	}
+	chosen := make([]hmmCandidate, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		chosen[i] = chain[i].candidates[best]
+		best = chain[i].back[best]
+	}
+
+	route.Route = append(route.Route, chosen[0].position)
+	for i := 1; i < len(chosen); i++ {
+		from, to := points[chain[i-1].point], points[chain[i].point]
+		targets := []hmmCandidate{chosen[i]}
+		distances, search := m.graph.shortestPaths(chosen[i-1], targets, maxRouteDistance(Distance(from.Lat, from.Lon, to.Lat, to.Lon)))
+		path, roads := m.graph.pathTo(search, chosen[i-1], chosen[i])
+		leg := RouteLeg{
+			Distance: distances[0],
+			Roads:    roads,
+			Start:    from.Timestamp,
+			End:      to.Timestamp,
+		}
+		for _, road := range roads {
+			leg.Duration += road.Duration
+		}
+		route.Route = append(route.Route, path...)
+		route.Legs = append(route.Legs, leg)
+		route.Distance += leg.Distance
+		route.Duration += leg.Duration
+	}
+	return route
+}
+
+//maxRouteDistance bounds the path search between two points, detours longer than this are not plausible
+func maxRouteDistance(straight float64) float64 {
+	return straight*3 + 200
+}
+
+//pathSearch holds the result of a shortest path search starting at the end node of the source edge
+type pathSearch struct {
+	start    int32
+	distance map[int32]float64 // meters to settled nodes, including the rest of the source edge
+	via      map[int32]int32   // edge over which a node was reached
+}
+
+//shortestPaths returns the road distance from source to each target, +Inf for targets farther than maxDistance
+func (g *roadGraph) shortestPaths(source hmmCandidate, targets []hmmCandidate, maxDistance float64) ([]float64, pathSearch) {
+	sourceEdge := g.edges[source.edge]
+	search := pathSearch{
+		start:    sourceEdge.to,
+		distance: make(map[int32]float64),
+		via:      make(map[int32]int32),
+	}
+	pending := make(map[int32]bool)
+	for _, target := range targets {
+		pending[g.edges[target.edge].from] = true
+	}
+
+	queue := &nodeQueue{{node: sourceEdge.to, distance: (1 - source.fraction) * sourceEdge.length}}
+	for queue.Len() > 0 && len(pending) > 0 {
+		current := heap.Pop(queue).(queuedNode)
+		if _, settled := search.distance[current.node]; settled {
+			continue
+		}
+		if current.distance > maxDistance {
+			break
+		}
+		search.distance[current.node] = current.distance
+		if current.node != search.start {
+			search.via[current.node] = current.via
+		}
+		delete(pending, current.node)
+		for _, edgeIndex := range g.outgoing[current.node] {
+			edge := g.edges[edgeIndex]
+			if _, settled := search.distance[edge.to]; !settled {
+				heap.Push(queue, queuedNode{node: edge.to, distance: current.distance + edge.length, via: edgeIndex})
+			}
+		}
+	}
+
+	distances := make([]float64, len(targets))
+	for k, target := range targets {
+		targetEdge := g.edges[target.edge]
+		if target.edge == source.edge && target.fraction >= source.fraction {
+			distances[k] = (target.fraction - source.fraction) * targetEdge.length
+		} else if distance, ok := search.distance[targetEdge.from]; ok && distance+target.fraction*targetEdge.length <= maxDistance {
+			distances[k] = distance + target.fraction*targetEdge.length
+		} else {
+			distances[k] = math.Inf(1)
+		}
+	}
+	return distances, search
+}
+
+//pathTo returns the positions along the road from source to target, without the source position, and the roads driven
+func (g *roadGraph) pathTo(search pathSearch, source hmmCandidate, target hmmCandidate) ([]Coordinates, []RoadSection) {
+	if target.edge == source.edge && target.fraction >= source.fraction {
+		return []Coordinates{target.position}, []RoadSection{g.road(source.edge, target.fraction-source.fraction)}
+	}
+
+	var edges []int32
+	for node := g.edges[target.edge].from; node != search.start; {
+		edge, ok := search.via[node]
+		if !ok {
+			//target was not reached by the search
+			return []Coordinates{target.position}, nil
+		}
+		edges = append([]int32{edge}, edges...)
+		node = g.edges[edge].from
+	}
+
+	start := g.nodes[search.start]
+	path := []Coordinates{{Lat: start.Lat, Lon: start.Lon}}
+	roads := appendRoad(nil, g.road(source.edge, 1-source.fraction))
+	for _, edge := range edges {
+		node := g.nodes[g.edges[edge].to]
+		path = append(path, Coordinates{Lat: node.Lat, Lon: node.Lon})
+		roads = appendRoad(roads, g.road(edge, 1))
+	}
+	roads = appendRoad(roads, g.road(target.edge, target.fraction))
+	return append(path, target.position), roads
+}
+
+type queuedNode struct {
+	node     int32
+	distance float64
+	via      int32
+}
+
+//nodeQueue is a min heap of nodes by distance for the path search
+type nodeQueue []queuedNode
+
+func (q nodeQueue) Len() int            { return len(q) }
+func (q nodeQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q nodeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(queuedNode)) }
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testHMMMatcher() *hmmMatcher {
+	return &hmmMatcher{graph: testRoadGraph("car"), sigma: 10, beta: 20, radius: 100, maxCandidates: 8}
+}
+
+func TestEmission(t *testing.T) {
+	m := testHMMMatcher()
+	cases := []struct {
+		distance float64
+		want     float64
+	}{
+		{0, 0},
+		{10, -0.5},
+		{20, -2},
+		{30, -4.5},
+	}
+	for _, c := range cases {
+		if got := m.emission(hmmCandidate{distance: c.distance}); !closeTo(got, c.want) {
+			t.Errorf("emission at %vm = %v, want %v", c.distance, got, c.want)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	m := testHMMMatcher()
+	length := m.graph.edges[testEdge(t, m.graph, 13, 12)].length
+	//two reports a quarter of the eastern Parallelweg apart, driven to the west
+	points := []SimulatorMessageData{
+		{Lat: 52.5004, Lon: 13.40875},
+		{Lat: 52.5004, Lon: 13.4075},
+	}
+	straight := Distance(points[0].Lat, points[0].Lon, points[1].Lat, points[1].Lon)
+	source := testCandidate(t, m.graph, 13, 12, 0.25)
+	previous := hmmStep{point: 0, candidates: []hmmCandidate{source}, scores: []float64{-1}, back: []int{0}}
+
+	cases := []struct {
+		name      string
+		candidate hmmCandidate
+		distance  float64 // meters of road from the source
+		ok        bool
+	}{
+		{"along the oneway", testCandidate(t, m.graph, 13, 12, 0.5), length / 4, true},
+		{"against the oneway", testCandidate(t, m.graph, 12, 11, 0), length * 3 / 4, true},
+		{"back up the oneway", testCandidate(t, m.graph, 13, 12, 0), 0, false},
+	}
+	for _, c := range cases {
+		c.candidate.distance = 5
+		step, ok := m.transition(points, previous, 1, []hmmCandidate{c.candidate})
+		if ok != c.ok {
+			t.Errorf("%s: ok = %v, want %v", c.name, ok, c.ok)
+			continue
+		}
+		if !ok {
+			if !math.IsInf(step.scores[0], -1) {
+				t.Errorf("%s: unreachable candidate scored %v", c.name, step.scores[0])
+			}
+			continue
+		}
+		want := -1 - math.Abs(c.distance-straight)/m.beta + m.emission(c.candidate)
+		if !closeTo(step.scores[0], want) || step.back[0] != 0 {
+			t.Errorf("%s: score %v from %d, want %v from 0", c.name, step.scores[0], step.back[0], want)
+		}
+	}
+}
+
+func TestMatchPicksTheRoadOverTheNearerParallelOne(t *testing.T) {
+	m := testHMMMatcher()
+	at := func(seconds int, lat float64, lon float64) SimulatorMessageData {
+		return SimulatorMessageData{Timestamp: testStart.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339), Lat: lat, Lon: lon}
+	}
+	//a car drives east on the Hauptstraße, the last report is pulled towards the Parallelweg. Its nearest
+	//candidate is on the oneway, which is reached over the Ostweg on a detour far longer than the reports are apart.
+	points := []SimulatorMessageData{
+		at(0, 52.5001, 13.4035),
+		at(10, 52.5001, 13.4050),
+		at(20, 52.5001, 13.4065),
+		at(30, 52.50025, 13.4080),
+	}
+	last := points[len(points)-1]
+	if nearest := m.candidates(last)[0]; m.graph.ways[m.graph.edges[nearest.edge].way].name != "Parallelweg" {
+		t.Fatalf("the noisy report is closest to the %s, the test needs it closest to the Parallelweg", m.graph.ways[m.graph.edges[nearest.edge].way].name)
+	}
+
+	route, err := m.Match(points)
+	if err != nil {
+		t.Fatalf("match failed: %v", err)
+	}
+	if len(route.Legs) != len(points)-1 || len(route.Unmatched) != 0 || route.Confidence != 1 {
+		t.Fatalf("got %d legs, %d unmatched and confidence %v, want %d legs of one matching", len(route.Legs), len(route.Unmatched), route.Confidence, len(points)-1)
+	}
+	for i, leg := range route.Legs {
+		for _, road := range leg.Roads {
+			if road.Name != "Hauptstraße" {
+				t.Errorf("leg %d uses the %s", i, road.Name)
+			}
+		}
+		if leg.Start != points[i].Timestamp || leg.End != points[i+1].Timestamp {
+			t.Errorf("leg %d runs %s to %s", i, leg.Start, leg.End)
+		}
+	}
+	for _, position := range route.Route {
+		if !closeTo(position.Lat, 52.5) {
+			t.Errorf("route leaves the Hauptstraße at %v", position)
+		}
+	}
+	wantDistance := Distance(52.5, 13.4035, 52.5, 13.4080)
+	if math.Abs(route.Distance-wantDistance) > 1 {
+		t.Errorf("distance = %v, want %v", route.Distance, wantDistance)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+
+//gapRouter is implemented by matchers which can route between two points to fill a gap in the trace
+type gapRouter interface {
+	Route(from SimulatorMessageData, to SimulatorMessageData) (MatchedRoute, error)
+}
+
+//isGap reports whether the car lost reception between the two points, e.g. while driving through a tunnel.
+//Reports were missed if the points are more than two reporting intervals apart, a fast car reporting on
+//schedule is matched however far it got. Points without valid timestamps are judged by distance alone.
+func isGap(from SimulatorMessageData, to SimulatorMessageData) bool {
+	if Distance(from.Lat, from.Lon, to.Lat, to.Lon) < gapMinDistance {
+		return false
+	}
+	start, end, err := pointTimes(from, to)
+	return err != nil || end.Sub(start) > 2*reportInterval
+}
+
+//matchWithGaps matches the runs of points between gaps and routes from the last point before each gap
+//to the first one after it. Matchers which cannot route match the whole trace as before.
+func matchWithGaps(m Matcher, points []SimulatorMessageData) (MatchedRoute, error) {
+	router, ok := m.(gapRouter)
+	if !ok {
+		return m.Match(points)
+	}
+
+	var route MatchedRoute
+	start := 0
+	for i := 1; i <= len(points); i++ {
+		if i < len(points) && !isGap(points[i-1], points[i]) {
+			continue
+		}
+		//a single point between two gaps is covered by the routes on both sides
+		if i-start >= 2 {
+			matched, err := m.Match(points[start:i])
+			if err != nil {
+				return MatchedRoute{}, err
+			}
+			route = joinRoutes(route, matched)
+		}
+		if i < len(points) {
+			fmt.Printf("--- Interpolating gap of %.0fm between messages %d and %d ---\n",
+				Distance(points[i-1].Lat, points[i-1].Lon, points[i].Lat, points[i].Lon), points[i-1].MessageID, points[i].MessageID)
+			interpolated, err := router.Route(points[i-1], points[i])
+			if err != nil {
+				return MatchedRoute{}, err
+			}
+			route = joinRoutes(route, interpolated)
+		}
+		start = i
+	}
+	return route, nil
+}
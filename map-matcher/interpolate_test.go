@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsGap(t *testing.T) {
+	defer func(interval time.Duration) { reportInterval = interval }(reportInterval)
+	reportInterval = time.Minute
+
+	at := func(seconds int, north float64) SimulatorMessageData {
+		return SimulatorMessageData{
+			Timestamp: testStart.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339),
+			Lat:       52.5 + north/metersPerDegree,
+			Lon:       13.4,
+		}
+	}
+	untimed := func(point SimulatorMessageData) SimulatorMessageData {
+		point.Timestamp = ""
+		return point
+	}
+	cases := []struct {
+		name     string
+		from, to SimulatorMessageData
+		gap      bool
+	}{
+		{"motorway on schedule", at(0, 0), at(60, 1700), false},
+		{"reports missed", at(0, 0), at(180, 1700), true},
+		{"short pause", at(0, 0), at(600, 500), false},
+		{"no timestamps", untimed(at(0, 0)), untimed(at(60, 1700)), true},
+	}
+	for _, c := range cases {
+		if gap := isGap(c.from, c.to); gap != c.gap {
+			t.Errorf("%s: isGap = %v, want %v", c.name, gap, c.gap)
+		}
+	}
+}
@@ -3,9 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
 	"time"
@@ -21,18 +19,31 @@ var (
 	publishQueueName   = "location.matched"
 	logQueueName       = "logs"
 	globalNatsConn     *nats.Conn
-	messageQueue       = make(map[string][]SimulatorMessageData) // car id to locations dict; example id:locations:[.., .., .., ]
 	messageQueueLength = 2
+	carIdleTTL         = 5 * time.Minute // cars not reporting for this long are evicted and their points flushed
+	carStates          *carStore
+	statePath          = os.Getenv("STATE_PATH") // bbolt file the car states are kept in, in memory only if empty
+	unmatchedQueueName = "location.unmatched"
+	reviewQueueName    = "location.review"
+	minConfidence      = 0.5                      // matches below are flagged and additionally published for review
+	matchRadiuses      = []float64{100, 250, 500} // search radiuses in meters, widened when osrm finds no match
+	valhallaURI        = os.Getenv("VALHALLA_URI")
+	osmPBFPath         = os.Getenv("OSM_PBF_PATH")
+	matcherBackend     = os.Getenv("MATCHER_BACKEND") // osrm, valhalla, hmm or noop
+	gapMinDistance     = 1000.0                       // meters between consecutive points from which the road in between is interpolated
+	reportInterval     = 10 * time.Second             // expected time between two reports of a car, longer silences may be gaps
 )
 
 //SimulatorMessageData received by the Simulator
 type SimulatorMessageData struct {
-	MessageID int     `json:"messageId"`
-	CarID     string  `json:"carId"`
-	Timestamp string  `json:"timestamp"`
-	Accuracy  float64 `json:"accuracy"`
-	Lat       float64 `json:"lat,float64"`
-	Lon       float64 `json:"lon,float64"`
+	MessageID   int     `json:"messageId"`
+	CarID       string  `json:"carId"`
+	Timestamp   string  `json:"timestamp"`
+	Accuracy    float64 `json:"accuracy"`
+	Lat         float64 `json:"lat,float64"`
+	Lon         float64 `json:"lon,float64"`
+	VehicleType string  `json:"vehicleType,omitempty"` // car, truck or cargo_bike, looked up in the registry if not reported
+	TripID      string  `json:"tripId,omitempty"`      // assigned by map-matcher
 }
 
 func (s SimulatorMessageData) toString() string {
@@ -78,12 +89,22 @@ func (m MapMatcherOutput) toString() string {
 
 // MapMatcherMessage struct
 type MapMatcherMessage struct {
-	MessageID int           `json:"messageId"`
-	CarID     string        `json:"carId"`
-	Timestamp string        `json:"timestamp"`
-	Route     []Coordinates `json:"route"`
-	Sender    string        `json:"sender"`
-	Topic     string        `json:"topic"`
+	MessageID            int           `json:"messageId"`
+	CarID                string        `json:"carId"`
+	TripID               string        `json:"tripId"`
+	VehicleType          string        `json:"vehicleType"`
+	Timestamp            string        `json:"timestamp"`
+	ReportTimestamp      string        `json:"reportTimestamp"` // timestamp of the latest matched report
+	Route                []Coordinates `json:"route"`
+	Legs                 []RouteLeg    `json:"legs"`
+	Distance             float64       `json:"distance"`
+	Duration             float64       `json:"duration"`
+	Confidence           float64       `json:"confidence"`
+	LowConfidence        bool          `json:"lowConfidence"`
+	Interpolated         bool          `json:"interpolated"` // parts of the route were routed through gaps in the trace
+	InterpolatedDistance float64       `json:"interpolatedDistance"`
+	Sender               string        `json:"sender"`
+	Topic                string        `json:"topic"`
 }
 
 func (m MapMatcherMessage) toString() string {
@@ -96,39 +117,85 @@ type Coordinates struct {
 	Lon float64 `json:"lon"`
 }
 
-// OSRMResponse from the OSRM server
-type OSRMResponse struct {
-	Code        string
-	Tracepoints []Tracepoints
+//RouteLeg is the part of the matched route between two consecutive tracepoints
+type RouteLeg struct {
+	Distance     float64       `json:"distance"`
+	Duration     float64       `json:"duration"`
+	Roads        []RoadSection `json:"roads"`
+	Interpolated bool          `json:"interpolated,omitempty"` // routed through a gap in the trace instead of matched
+	Start        string        `json:"start,omitempty"`        // report timestamps of the points the leg connects
+	End          string        `json:"end,omitempty"`
 }
 
-// Tracepoints <meaningful comment>
-type Tracepoints struct {
-	AternativesCount int
-	Location         []float64
-	Distance         float64
-	Hint             string
-	Name             string
-	MatchinIndex     int
-	WaypointIndex    int
+//RoadSection is the part of a leg driven on a single road. Way ids and the road class are only known
+//to backends working on the osm data, osrm reports the classes of its profile instead.
+type RoadSection struct {
+	Name      string   `json:"name"`
+	Ref       string   `json:"ref,omitempty"`
+	WayID     int64    `json:"wayId,omitempty"`
+	NodeIDs   []int64  `json:"nodeIds,omitempty"`
+	RoadClass string   `json:"roadClass,omitempty"`
+	Classes   []string `json:"classes,omitempty"`
+	MaxSpeed  float64  `json:"maxSpeed,omitempty"` // speed limit in km/h, 0 if unknown
+	Distance  float64  `json:"distance"`
+	Duration  float64  `json:"duration"`
 }
 
-func (r OSRMResponse) toString() string {
-	return fmt.Sprintf("%+v\n", r)
+//UnmatchedMessage is published for points which could not be matched onto a road
+type UnmatchedMessage struct {
+	MessageID int                    `json:"messageId"`
+	CarID     string                 `json:"carId"`
+	TripID    string                 `json:"tripId"`
+	Timestamp string                 `json:"timestamp"`
+	Points    []SimulatorMessageData `json:"points"`
+	Code      string                 `json:"code"`
+	Reason    string                 `json:"reason"`
+	Sender    string                 `json:"sender"`
+	Topic     string                 `json:"topic"`
 }
 
-func pushToMessageQueue(ms SimulatorMessageData) {
+//UnmatchedOutput message
+type UnmatchedOutput struct {
+	Data UnmatchedMessage `json:"data"`
+}
 
-	messageQueue[ms.CarID] = append(messageQueue[ms.CarID], ms)
+func (u UnmatchedOutput) toString() string {
+	return fmt.Sprintf("%+v\n", u)
+}
 
-	if len(messageQueue[ms.CarID]) >= messageQueueLength {
-		msg1 := messageQueue[ms.CarID][len(messageQueue[ms.CarID])-1]
-		msg2 := messageQueue[ms.CarID][len(messageQueue[ms.CarID])-2]
-		messageQueue[ms.CarID] = messageQueue[ms.CarID][:len(messageQueue[ms.CarID])-1]
-		messageQueue[ms.CarID] = messageQueue[ms.CarID][:len(messageQueue[ms.CarID])-1]
-		go processMessage(msg1, msg2)
-	}
+func pushToMessageQueue(msg SimulatorMessage) {
+	msg.Data.VehicleType = vehicleTypeOf(msg.Data)
+	carStates.push(msg.Data, msg.Event, time.Now())
+}
 
+//handleCarUpdate queues the update on the worker of its car, the store calls it while the car is locked
+func handleCarUpdate(update carUpdate) {
+	updateWorkers.enqueue(update)
+}
+
+//processCarUpdate matches the batches of the update and announces its trips. A trip ends once its last
+//batch is matched, the batch of the trip started by the same point comes after.
+func processCarUpdate(update carUpdate) {
+	if update.Rejected != nil {
+		publishRejectedMessage(*update.Rejected)
+	}
+	if update.Parked != nil {
+		publishParkedMessage(*update.Parked)
+	}
+	batches := update.Batches
+	if update.Ended != nil {
+		for len(batches) > 0 && batches[0][len(batches[0])-1].TripID == update.Ended.Trip.ID {
+			processMessage(batches[0])
+			batches = batches[1:]
+		}
+		publishTripMessage(tripEndedQueueName, *update.Ended)
+	}
+	if update.Started != nil {
+		publishTripMessage(tripStartedQueueName, *update.Started)
+	}
+	for _, points := range batches {
+		processMessage(points)
+	}
 }
 
 func main() {
@@ -145,10 +212,68 @@ func main() {
 		log.Fatal(err)
 	}
 	globalNatsConn = nc
+	if vehicleRegistryPath != "" {
+		if err := loadVehicleRegistry(vehicleRegistryPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//building a road graph takes a while, it is not done while the first reports wait
+	for _, vehicleType := range configuredVehicleTypes() {
+		if _, err := matcherFor(vehicleType); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("CAR_IDLE_TTL")); err == nil {
+		carIdleTTL = ttl
+	}
+	var persistence carStatePersistence
+	if statePath != "" {
+		persistence, err = newBoltPersistence(statePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	carStates, err = newCarStore(messageQueueLength, carIdleTTL, persistence, handleCarUpdate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if gap, err := time.ParseDuration(os.Getenv("TRIP_MAX_GAP")); err == nil {
+		tripMaxGap = gap
+	}
+	if jump, err := strconv.ParseFloat(os.Getenv("TRIP_MAX_JUMP"), 64); err == nil {
+		tripMaxJump = jump
+	}
+	if accuracy, err := strconv.ParseFloat(os.Getenv("MAX_ACCURACY"), 64); err == nil {
+		maxAccuracy = accuracy
+	}
+	if speed, err := strconv.ParseFloat(os.Getenv("MAX_SPEED"), 64); err == nil {
+		maxSpeed = speed
+	}
+	if noise, err := strconv.ParseFloat(os.Getenv("KALMAN_PROCESS_NOISE"), 64); err == nil {
+		kalmanProcessNoise = noise
+	}
+	if confidence, err := strconv.ParseFloat(os.Getenv("MIN_MATCH_CONFIDENCE"), 64); err == nil {
+		minConfidence = confidence
+	}
+	if gap, err := strconv.ParseFloat(os.Getenv("GAP_MIN_DISTANCE"), 64); err == nil {
+		gapMinDistance = gap
+	}
+	if interval, err := time.ParseDuration(os.Getenv("REPORT_INTERVAL")); err == nil {
+		reportInterval = interval
+	}
+	if tolerance, err := strconv.ParseFloat(os.Getenv("SPEEDING_TOLERANCE"), 64); err == nil {
+		speedingTolerance = tolerance
+	}
+	if distance, err := strconv.ParseFloat(os.Getenv("STATIONARY_DISTANCE"), 64); err == nil {
+		stationaryDistance = distance
+	}
+	if dwell, err := time.ParseDuration(os.Getenv("PARKED_MIN_DURATION")); err == nil {
+		parkedMinDuration = dwell
+	}
+	go carStates.runEviction()
 
-	nc.Subscribe(subscribeQueueName, func(m *nats.Msg) {
-		go subscribeHandler(m)
-	})
+	//the reports are pushed in the order they arrive, matching runs on the workers of the cars
+	nc.Subscribe(subscribeQueueName, subscribeHandler)
 
 	// Run server
 	if err := service.Run(); err != nil {
@@ -169,7 +294,7 @@ func subscribeHandler(m *nats.Msg) {
 		fmt.Println("error:", err)
 	}
 	logMessage(msg.Data.MessageID, "received")
-	pushToMessageQueue(msg.Data)
+	pushToMessageQueue(msg)
 }
 
 func logMessage(MessageID int, msgType string) {
@@ -194,52 +319,52 @@ func logMessage(MessageID int, msgType string) {
 	fmt.Println(string(logOutput))
 }
 
-func processMessage(msg1 SimulatorMessageData, msg2 SimulatorMessageData) {
-	fmt.Println("----MESSAGE1----")
-	fmt.Println(msg1.toString())
-	fmt.Println("----MESSAGE2----")
-	fmt.Println(msg2.toString())
-	fmt.Println("---sending Data to osrm---")
-	resp, err := http.Get("http://" + osrmURI + "/match/v1/car/" + strconv.FormatFloat(msg1.Lon, 'f', -1, 64) + "," + strconv.FormatFloat(msg1.Lat, 'f', -1, 64) + ";" + strconv.FormatFloat(msg2.Lon, 'f', -1, 64) + "," + strconv.FormatFloat(msg2.Lat, 'f', -1, 64) + "?radiuses=100.0;100.0" /*strconv.FormatFloat(msg1.Accuracy, 'f', -1, 64)*/ /* + strconv.FormatFloat(msg2.Accuracy, 'f', -1, 64)*/)
+func processMessage(points []SimulatorMessageData) {
+	latest := points[len(points)-1]
+	for i, point := range points {
+		fmt.Printf("----MESSAGE%d----\n", i+1)
+		fmt.Println(point.toString())
+	}
+
+	route, err := matchPoints(latest.VehicleType, points)
 	if err != nil {
-		fmt.Printf("--- OSRM error!----\n")
+		fmt.Printf("--- Matching error!----\n")
 		fmt.Println(err)
+		if matchErr, ok := err.(MatchError); ok {
+			publishUnmatchedMessage(points, matchErr.Code, matchErr.Reason)
+		} else {
+			publishUnmatchedMessage(points, "RequestFailed", err.Error())
+		}
 		return
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	var osrmRes OSRMResponse
-	rawJSON := json.RawMessage(body)
-	bytes, err := rawJSON.MarshalJSON()
-	if err != nil {
-		fmt.Println("---error:---\n", err)
-		return
+	if len(route.Unmatched) > 0 {
+		publishUnmatchedMessage(route.Unmatched, "NoMatch", "point could not be matched onto a road")
 	}
-	err2 := json.Unmarshal(bytes, &osrmRes)
-	if err2 != nil {
-		fmt.Println("---error:---\n", err)
+	if len(route.Route) == 0 {
 		return
 	}
 
-	fmt.Printf("--- OSRM output----\n")
-	fmt.Println(osrmRes.toString())
-
 	msgData := MapMatcherMessage{
-		Sender:    "GoMicro-MapMatcher",
-		Topic:     "location.matched",
-		MessageID: msg1.MessageID,
-		CarID:     msg1.CarID,
-		Timestamp: time.Now().Local().Format(time.RFC3339),
-		Route: []Coordinates{
-			Coordinates{
-				Lat: osrmRes.Tracepoints[0].Location[1],
-				Lon: osrmRes.Tracepoints[0].Location[0],
-			},
-			Coordinates{
-				Lat: osrmRes.Tracepoints[1].Location[1],
-				Lon: osrmRes.Tracepoints[1].Location[0],
-			},
-		},
+		Sender:          "GoMicro-MapMatcher",
+		Topic:           "location.matched",
+		MessageID:       latest.MessageID,
+		CarID:           latest.CarID,
+		TripID:          latest.TripID,
+		VehicleType:     latest.VehicleType,
+		Timestamp:       time.Now().Local().Format(time.RFC3339),
+		ReportTimestamp: latest.Timestamp,
+		Route:           route.Route,
+		Legs:            route.Legs,
+		Distance:        route.Distance,
+		Duration:        route.Duration,
+		Confidence:      route.Confidence,
+		LowConfidence:   route.Confidence < minConfidence,
+	}
+	for _, leg := range route.Legs {
+		if leg.Interpolated {
+			msgData.Interpolated = true
+			msgData.InterpolatedDistance += leg.Distance
+		}
 	}
 
 	mmOutput := MapMatcherOutput{
@@ -247,6 +372,58 @@ func processMessage(msg1 SimulatorMessageData, msg2 SimulatorMessageData) {
 	}
 
 	publishMapMatcherMessage(mmOutput)
+	if msgData.LowConfidence {
+		publishReviewMessage(mmOutput)
+	}
+	publishSpeeds(msgData)
+}
+
+//matchPoints matches the points with the matcher of the vehicle type
+func matchPoints(vehicleType string, points []SimulatorMessageData) (MatchedRoute, error) {
+	m, err := matcherFor(vehicleType)
+	if err != nil {
+		return MatchedRoute{}, err
+	}
+	return matchWithGaps(m, points)
+}
+
+func publishUnmatchedMessage(points []SimulatorMessageData, code string, reason string) {
+	latest := points[len(points)-1]
+	msg := UnmatchedOutput{
+		Data: UnmatchedMessage{
+			Sender:    "GoMicro-MapMatcher",
+			Topic:     unmatchedQueueName,
+			MessageID: latest.MessageID,
+			CarID:     latest.CarID,
+			TripID:    latest.TripID,
+			Timestamp: time.Now().Local().Format(time.RFC3339),
+			Points:    points,
+			Code:      code,
+			Reason:    reason,
+		},
+	}
+
+	unmatchedOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(unmatchedQueueName, unmatchedOutput)
+	logMessage(msg.Data.MessageID, "unmatched")
+	fmt.Println("---published unmatched message---\n" + msg.toString())
+}
+
+//publishReviewMessage puts a doubtful match into the review queue
+func publishReviewMessage(msg MapMatcherOutput) {
+	msg.Data.Topic = reviewQueueName
+	reviewOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(reviewQueueName, reviewOutput)
+	logMessage(msg.Data.MessageID, "review")
+	fmt.Printf("---published match with confidence %.2f for review---\n", msg.Data.Confidence)
 }
 
 func publishMapMatcherMessage(msg MapMatcherOutput) {
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//MatchedRoute is the road path a car's points were matched onto
+type MatchedRoute struct {
+	Route      []Coordinates
+	Legs       []RouteLeg
+	Distance   float64
+	Duration   float64
+	Confidence float64
+	Unmatched  []SimulatorMessageData // points the backend could not snap onto a road
+}
+
+//Matcher snaps a car's points, given in driving order, onto the road network
+type Matcher interface {
+	Match(points []SimulatorMessageData) (MatchedRoute, error)
+}
+
+//MatchError is returned when the backend answered but could not match the points
+type MatchError struct {
+	Code   string
+	Reason string
+}
+
+func (e MatchError) Error() string {
+	return e.Code + ": " + e.Reason
+}
+
+//newMatcher creates the matching backend selected by MATCHER_BACKEND for the vehicle type
+func newMatcher(backend string, vehicleType string) (Matcher, error) {
+	switch backend {
+	case "", "osrm":
+		uri, err := osrmURIFor(vehicleType)
+		if err != nil {
+			return nil, err
+		}
+		return newOSRMMatcher(uri, vehicleProfiles[vehicleType].osrm), nil
+	case "valhalla":
+		return newValhallaMatcher(valhallaURI, vehicleProfiles[vehicleType].valhalla), nil
+	case "hmm":
+		return newHMMMatcher(osmPBFPath, vehicleType)
+	case "noop":
+		return noopMatcher{}, nil
+	}
+	return nil, fmt.Errorf("unknown matcher backend %q", backend)
+}
+
+//noopMatcher snaps nothing and returns the reported points as the route, it is meant for tests and benchmarks
+type noopMatcher struct{}
+
+func (noopMatcher) Match(points []SimulatorMessageData) (MatchedRoute, error) {
+	route := MatchedRoute{Confidence: 1}
+	for i, point := range points {
+		route.Route = append(route.Route, Coordinates{Lat: point.Lat, Lon: point.Lon})
+		if i == 0 {
+			continue
+		}
+		leg := RouteLeg{
+			Distance: Distance(points[i-1].Lat, points[i-1].Lon, point.Lat, point.Lon),
+		}
+		if from, to, err := pointTimes(points[i-1], point); err == nil {
+			leg.Duration = to.Sub(from).Seconds()
+		}
+		leg.Start, leg.End = points[i-1].Timestamp, point.Timestamp
+		route.Legs = append(route.Legs, leg)
+		route.Distance += leg.Distance
+		route.Duration += leg.Duration
+	}
+	return route, nil
+}
+
+//appendRoad appends the section to the roads, it is merged into the last one if both are on the same road
+func appendRoad(roads []RoadSection, road RoadSection) []RoadSection {
+	if len(roads) == 0 {
+		return append(roads, road)
+	}
+	last := &roads[len(roads)-1]
+	sameRoad := last.WayID == road.WayID && last.Name == road.Name && last.Ref == road.Ref && last.RoadClass == road.RoadClass
+	if !sameRoad {
+		return append(roads, road)
+	}
+	last.Distance += road.Distance
+	last.Duration += road.Duration
+	if road.MaxSpeed > last.MaxSpeed {
+		last.MaxSpeed = road.MaxSpeed
+	}
+	for _, nodeID := range road.NodeIDs {
+		if n := len(last.NodeIDs); n == 0 || last.NodeIDs[n-1] != nodeID {
+			last.NodeIDs = append(last.NodeIDs, nodeID)
+		}
+	}
+	for _, class := range road.Classes {
+		if !containsString(last.Classes, class) {
+			last.Classes = append(last.Classes, class)
+		}
+	}
+	return roads
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//pointTimes parses the report timestamps of two points
+func pointTimes(from SimulatorMessageData, to SimulatorMessageData) (time.Time, time.Time, error) {
+	fromTime, err := time.Parse(time.RFC3339, from.Timestamp)
+	if err != nil {
+		return fromTime, fromTime, err
+	}
+	toTime, err := time.Parse(time.RFC3339, to.Timestamp)
+	return fromTime, toTime, err
+}
@@ -0,0 +1,220 @@
+//Package osrm is a client for the OSRM HTTP API with timeouts, retries,
+//a circuit breaker per backend and round robin load balancing.
+package osrm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrCircuitOpen is returned when the circuit breakers of all backends are open
+var ErrCircuitOpen = errors.New("osrm: all backends are unavailable, circuit breaker open")
+
+//Options to configure the client, unset durations and thresholds fall back to DefaultOptions
+type Options struct {
+	Timeout          time.Duration // per request attempt
+	Retries          int           // additional attempts after a failed one
+	Backoff          time.Duration // base delay between attempts, doubled each retry and jittered
+	FailureThreshold int           // consecutive failures after which a backend is skipped
+	Cooldown         time.Duration // how long a backend is skipped before it is probed again
+}
+
+//DefaultOptions used for unset fields
+var DefaultOptions = Options{
+	Timeout:          5 * time.Second,
+	Retries:          2,
+	Backoff:          100 * time.Millisecond,
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+}
+
+//Point is a position given as longitude and latitude
+type Point struct {
+	Lon float64
+	Lat float64
+}
+
+//MatchOptions for a match request
+type MatchOptions struct {
+	Profile     string    // osrm profile, car if empty
+	Radiuses    []float64 // search radius in meters per point, osrm default if empty
+	Annotations bool      // request steps and node, distance, duration, speed and maxspeed annotations for each leg
+}
+
+//RouteOptions for a route request
+type RouteOptions struct {
+	Profile     string // osrm profile, car if empty
+	Annotations bool   // request steps and node, distance, duration, speed and maxspeed annotations for each leg
+}
+
+type backend struct {
+	uri     string
+	breaker *breaker
+}
+
+//Client for one or more OSRM backends serving the same data
+type Client struct {
+	backends   []*backend
+	httpClient *http.Client
+	options    Options
+	mutex      sync.Mutex
+	next       int
+}
+
+//NewClient creates a client balancing over the given backends, a backend may be given as host:port or full URL
+func NewClient(uris []string, options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultOptions.Timeout
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultOptions.Backoff
+	}
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = DefaultOptions.FailureThreshold
+	}
+	if options.Cooldown <= 0 {
+		options.Cooldown = DefaultOptions.Cooldown
+	}
+
+	client := &Client{
+		httpClient: &http.Client{Timeout: options.Timeout},
+		options:    options,
+	}
+	for _, uri := range uris {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+		if !strings.Contains(uri, "://") {
+			uri = "http://" + uri
+		}
+		client.backends = append(client.backends, &backend{
+			uri:     strings.TrimRight(uri, "/"),
+			breaker: newBreaker(options.FailureThreshold, options.Cooldown),
+		})
+	}
+	return client
+}
+
+//Match snaps the points in driving order onto the road network and returns the full geojson geometry
+func (c *Client) Match(points []Point, options MatchOptions) (Response, error) {
+	query := []string{"geometries=geojson", "overview=full"}
+	if len(options.Radiuses) > 0 {
+		radiuses := make([]string, 0, len(options.Radiuses))
+		for _, radius := range options.Radiuses {
+			radiuses = append(radiuses, strconv.FormatFloat(radius, 'f', 1, 64))
+		}
+		query = append(query, "radiuses="+strings.Join(radiuses, ";"))
+	}
+	if options.Annotations {
+		query = append(query, "steps=true", "annotations=nodes,distance,duration,speed,maxspeed")
+	}
+	return c.get("match", options.Profile, points, query)
+}
+
+//Route returns the fastest route visiting the points in order with its full geojson geometry
+func (c *Client) Route(points []Point, options RouteOptions) (Response, error) {
+	query := []string{"geometries=geojson", "overview=full"}
+	if options.Annotations {
+		query = append(query, "steps=true", "annotations=nodes,distance,duration,speed,maxspeed")
+	}
+	return c.get("route", options.Profile, points, query)
+}
+
+//get requests the service for the points, query parameters are given as key=value and are not escaped
+//because osrm expects the ; separators of list values verbatim
+func (c *Client) get(service string, profile string, points []Point, query []string) (Response, error) {
+	if profile == "" {
+		profile = "car"
+	}
+	coordinates := make([]string, 0, len(points))
+	for _, point := range points {
+		coordinates = append(coordinates, strconv.FormatFloat(point.Lon, 'f', -1, 64)+","+strconv.FormatFloat(point.Lat, 'f', -1, 64))
+	}
+	path := "/" + service + "/v1/" + profile + "/" + strings.Join(coordinates, ";") + "?" + strings.Join(query, "&")
+
+	var lastErr error
+	for attempt := 0; attempt <= c.options.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.backoff(attempt))
+		}
+		backend := c.pick()
+		if backend == nil {
+			return Response{}, ErrCircuitOpen
+		}
+		response, retry, err := c.do(backend.uri + path)
+		if err == nil {
+			backend.breaker.success()
+			return response, nil
+		}
+		lastErr = err
+		if !retry {
+			//the backend answered properly, the request itself is wrong
+			backend.breaker.success()
+			return response, err
+		}
+		backend.breaker.failure()
+	}
+	return Response{}, lastErr
+}
+
+//do sends a single request, retry reports whether the error is worth another attempt
+func (c *Client) do(uri string) (response Response, retry bool, err error) {
+	resp, err := c.httpClient.Get(uri)
+	if err != nil {
+		return response, true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response, true, err
+	}
+	if resp.StatusCode >= 500 {
+		return response, true, fmt.Errorf("osrm responded with status %d", resp.StatusCode)
+	}
+
+	//osrm answers errors with a json body containing the code as well
+	if err := json.Unmarshal(body, &response); err != nil {
+		return response, false, fmt.Errorf("osrm responded with status %d: %v", resp.StatusCode, err)
+	}
+	if response.Code == "" {
+		return response, false, fmt.Errorf("osrm responded with status %d without a code", resp.StatusCode)
+	}
+	return response, false, nil
+}
+
+//pick returns the next backend in round robin order whose circuit breaker is closed
+func (c *Client) pick() *backend {
+	if len(c.backends) == 0 {
+		return nil
+	}
+	c.mutex.Lock()
+	start := c.next
+	c.next = (c.next + 1) % len(c.backends)
+	c.mutex.Unlock()
+
+	for i := range c.backends {
+		backend := c.backends[(start+i)%len(c.backends)]
+		if backend.breaker.allow() {
+			return backend
+		}
+	}
+	return nil
+}
+
+//backoff returns the exponential delay before the given attempt with full jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.options.Backoff << uint(attempt-1)
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
@@ -0,0 +1,122 @@
+package osrm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPoints = []Point{{Lon: 13.388860, Lat: 52.517037}, {Lon: 13.397634, Lat: 52.529407}}
+
+//fakeOSRM answers each request with the status and body of the next reply, the last reply repeats
+func fakeOSRM(t *testing.T, hits *int32, replies ...func(w http.ResponseWriter)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit := int(atomic.AddInt32(hits, 1))
+		if hit > len(replies) {
+			hit = len(replies)
+		}
+		replies[hit-1](w)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func reply(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func testOptions() Options {
+	return Options{Timeout: time.Second, Retries: 2, Backoff: time.Millisecond, FailureThreshold: 5, Cooldown: time.Minute}
+}
+
+func TestServerErrorIsRetried(t *testing.T) {
+	var hits int32
+	server := fakeOSRM(t, &hits, reply(http.StatusServiceUnavailable, ""), reply(http.StatusOK, `{"code":"Ok"}`))
+	client := NewClient([]string{server.URL}, testOptions())
+
+	response, err := client.Match(testPoints, MatchOptions{})
+	if err != nil {
+		t.Fatalf("match failed: %v", err)
+	}
+	if response.Code != "Ok" {
+		t.Errorf("code = %q, want Ok", response.Code)
+	}
+	if hits != 2 {
+		t.Errorf("backend was hit %d times, want 2", hits)
+	}
+}
+
+func TestClientErrorIsNotRetried(t *testing.T) {
+	var hits int32
+	server := fakeOSRM(t, &hits, reply(http.StatusBadRequest, `{"code":"InvalidQuery","message":"Query string malformed close to position 28"}`))
+	client := NewClient([]string{server.URL}, testOptions())
+
+	response, err := client.Route(testPoints, RouteOptions{})
+	if err != nil {
+		t.Fatalf("route failed: %v", err)
+	}
+	if response.Code != "InvalidQuery" || response.Reason() != "Query string malformed close to position 28" {
+		t.Errorf("response = %+v, want the InvalidQuery body", response)
+	}
+	if hits != 1 {
+		t.Errorf("backend was hit %d times, want 1", hits)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	var hits int32
+	server := fakeOSRM(t, &hits, reply(http.StatusInternalServerError, ""))
+	options := testOptions()
+	options.Retries = 0
+	options.FailureThreshold = 2
+	options.Cooldown = 100 * time.Millisecond
+	client := NewClient([]string{server.URL}, options)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Match(testPoints, MatchOptions{}); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("request %d: err = %v, want the server error", i+1, err)
+		}
+	}
+	if _, err := client.Match(testPoints, MatchOptions{}); err != ErrCircuitOpen {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if hits != 2 {
+		t.Errorf("backend was hit %d times while open, want 2", hits)
+	}
+
+	time.Sleep(options.Cooldown)
+	if _, err := client.Match(testPoints, MatchOptions{}); err == ErrCircuitOpen {
+		t.Fatal("breaker still open after the cooldown")
+	}
+	if hits != 3 {
+		t.Errorf("backend was hit %d times, want the probe as third", hits)
+	}
+}
+
+func TestRoundRobinSkipsOpenBackend(t *testing.T) {
+	var failingHits, healthyHits int32
+	failing := fakeOSRM(t, &failingHits, reply(http.StatusBadGateway, ""))
+	healthy := fakeOSRM(t, &healthyHits, reply(http.StatusOK, `{"code":"Ok"}`))
+	options := testOptions()
+	options.Retries = 1
+	options.FailureThreshold = 1
+	client := NewClient([]string{failing.URL, healthy.URL}, options)
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.Match(testPoints, MatchOptions{}); err != nil {
+			t.Fatalf("request %d failed: %v", i+1, err)
+		}
+	}
+	if failingHits != 1 {
+		t.Errorf("failing backend was hit %d times, want 1 before its breaker opened", failingHits)
+	}
+	if healthyHits != 4 {
+		t.Errorf("healthy backend was hit %d times, want 4", healthyHits)
+	}
+}
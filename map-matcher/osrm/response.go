@@ -0,0 +1,112 @@
+package osrm
+
+var errorReasons = map[string]string{
+	"InvalidUrl":     "URL string is invalid",
+	"InvalidService": "service name is invalid",
+	"InvalidVersion": "version is not found",
+	"InvalidOptions": "options are invalid",
+	"InvalidQuery":   "the query string is syntactically malformed",
+	"InvalidValue":   "the successfully parsed query parameters are invalid",
+	"NoSegment":      "one of the supplied input coordinates could not snap to street segment",
+	"NoMatch":        "no matchings found",
+	"NoRoute":        "no route found",
+	"TooBig":         "the request size violates one of the service specific request size restrictions",
+	"NotImplemented": "this request is not supported",
+}
+
+//Response from the OSRM match or route service, tracepoints of points which could not be matched are null
+type Response struct {
+	Code        string        `json:"code"`
+	Message     string        `json:"message"`
+	Tracepoints []*Tracepoint `json:"tracepoints"`
+	Matchings   []Matching    `json:"matchings"`
+	Routes      []Matching    `json:"routes"` // answer of the route service, routes have no confidence
+}
+
+//Reason returns a human readable description of a non Ok response
+func (r Response) Reason() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	if reason, ok := errorReasons[r.Code]; ok {
+		return reason
+	}
+	return "unknown osrm response code " + r.Code
+}
+
+//Tracepoint is an input point snapped onto the road network
+type Tracepoint struct {
+	AlternativesCount int       `json:"alternatives_count"`
+	Location          []float64 `json:"location"`
+	Distance          float64   `json:"distance"`
+	Hint              string    `json:"hint"`
+	Name              string    `json:"name"`
+	MatchingsIndex    int       `json:"matchings_index"`
+	WaypointIndex     int       `json:"waypoint_index"`
+}
+
+//Matching is one matched sub-trace with its full road geometry
+type Matching struct {
+	Confidence float64    `json:"confidence"`
+	Distance   float64    `json:"distance"`
+	Duration   float64    `json:"duration"`
+	Geometry   LineString `json:"geometry"`
+	Legs       []Leg      `json:"legs"`
+}
+
+//Leg is the part of a matching between two consecutive tracepoints
+type Leg struct {
+	Distance   float64    `json:"distance"`
+	Duration   float64    `json:"duration"`
+	Steps      []Step     `json:"steps"`
+	Annotation Annotation `json:"annotation"`
+}
+
+//Step is the part of a leg driven on one road
+type Step struct {
+	Distance      float64        `json:"distance"`
+	Duration      float64        `json:"duration"`
+	Name          string         `json:"name"`
+	Ref           string         `json:"ref"`
+	Mode          string         `json:"mode"`
+	Intersections []Intersection `json:"intersections"`
+}
+
+//Intersection passed in a step, classes are the road classes of the profile like motorway, toll or tunnel
+type Intersection struct {
+	Classes []string `json:"classes"`
+}
+
+//Annotation holds per segment values of a leg, a segment lies between two consecutive nodes
+type Annotation struct {
+	Nodes    []int64    `json:"nodes"`
+	Distance []float64  `json:"distance"`
+	Duration []float64  `json:"duration"`
+	Speed    []float64  `json:"speed"`
+	Maxspeed []Maxspeed `json:"maxspeed"`
+}
+
+//Maxspeed is the speed limit of a segment as tagged in osm
+type Maxspeed struct {
+	Speed   float64 `json:"speed"`
+	Unit    string  `json:"unit"`
+	Unknown bool    `json:"unknown"`
+	None    bool    `json:"none"` // no limit at all
+}
+
+//KilometersPerHour returns the limit in km/h, ok is false if the segment has no known limit
+func (m Maxspeed) KilometersPerHour() (speed float64, ok bool) {
+	if m.Unknown || m.None || m.Speed <= 0 {
+		return 0, false
+	}
+	if m.Unit == "mph" {
+		return m.Speed * 1.609344, true
+	}
+	return m.Speed, true
+}
+
+//LineString as returned for geometries=geojson, positions are [lon, lat]
+type LineString struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
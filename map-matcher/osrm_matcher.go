@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patreu22/go-clean/map-matcher/osrm"
+)
+
+//osrmMatcher matches with the match service of one or more osrm backends
+type osrmMatcher struct {
+	client  *osrm.Client
+	profile string
+}
+
+func newOSRMMatcher(uris string, profile string) *osrmMatcher {
+	return &osrmMatcher{
+		client:  osrm.NewClient(strings.Split(uris, ","), osrmOptions()),
+		profile: profile,
+	}
+}
+
+func (m *osrmMatcher) Match(points []SimulatorMessageData) (MatchedRoute, error) {
+	var route MatchedRoute
+	osrmPoints := make([]osrm.Point, 0, len(points))
+	for _, point := range points {
+		osrmPoints = append(osrmPoints, osrm.Point{Lon: point.Lon, Lat: point.Lat})
+	}
+
+	var osrmRes osrm.Response
+	var err error
+	for _, radius := range matchRadiuses {
+		fmt.Println("---sending Data to osrm---")
+		radiuses := make([]float64, len(points))
+		for i := range radiuses {
+			radiuses[i] = radius
+		}
+		osrmRes, err = m.client.Match(osrmPoints, osrm.MatchOptions{Profile: m.profile, Radiuses: radiuses, Annotations: true})
+		if err != nil {
+			return route, err
+		}
+		if osrmRes.Code != "NoMatch" && osrmRes.Code != "NoSegment" {
+			break
+		}
+		fmt.Printf("--- OSRM found no match within %vm ---\n", radius)
+	}
+
+	fmt.Printf("--- OSRM output----\n")
+	fmt.Printf("%+v\n", osrmRes)
+
+	switch osrmRes.Code {
+	case "Ok":
+	case "TooBig":
+		if len(points) > 2 {
+			//match both halves on their own, they share the middle point so no road part gets lost
+			middle := len(points) / 2
+			first, err := m.Match(points[:middle+1])
+			if err != nil {
+				return route, err
+			}
+			second, err := m.Match(points[middle:])
+			if err != nil {
+				return route, err
+			}
+			return joinRoutes(first, second), nil
+		}
+		return route, MatchError{Code: osrmRes.Code, Reason: osrmRes.Reason()}
+	default:
+		return route, MatchError{Code: osrmRes.Code, Reason: osrmRes.Reason()}
+	}
+
+	//osrm drops points it considers outliers and returns null for their tracepoint
+	for i, tracepoint := range osrmRes.Tracepoints {
+		if tracepoint == nil && i < len(points) {
+			route.Unmatched = append(route.Unmatched, points[i])
+		}
+	}
+
+	//legs of a matching run between its consecutive waypoints, which are the matched points in input order
+	waypoints := make([][]SimulatorMessageData, len(osrmRes.Matchings))
+	for i, tracepoint := range osrmRes.Tracepoints {
+		if tracepoint != nil && i < len(points) && tracepoint.MatchingsIndex < len(waypoints) {
+			waypoints[tracepoint.MatchingsIndex] = append(waypoints[tracepoint.MatchingsIndex], points[i])
+		}
+	}
+
+	//a trace may be split into several matchings, the gaps between them are routed like gaps in reception
+	//so the route never jumps straight from the end of one matching to the start of the next
+	for i, matching := range osrmRes.Matchings {
+		matched := appendMatching(MatchedRoute{}, matching, waypoints[i], false)
+		matched.Confidence = matching.Confidence
+		if i > 0 && len(waypoints[i-1]) > 0 && len(waypoints[i]) > 0 {
+			gap, err := m.Route(waypoints[i-1][len(waypoints[i-1])-1], waypoints[i][0])
+			if err != nil {
+				return MatchedRoute{}, err
+			}
+			route = joinRoutes(route, gap)
+		}
+		//joining keeps the lowest confidence, the route is only as trustworthy as its weakest matching
+		route = joinRoutes(route, matched)
+	}
+	return route, nil
+}
+
+//Route interpolates the road driven between two points with the fastest osrm route
+func (m *osrmMatcher) Route(from SimulatorMessageData, to SimulatorMessageData) (MatchedRoute, error) {
+	fmt.Println("---sending gap to osrm---")
+	points := []osrm.Point{{Lon: from.Lon, Lat: from.Lat}, {Lon: to.Lon, Lat: to.Lat}}
+	osrmRes, err := m.client.Route(points, osrm.RouteOptions{Profile: m.profile, Annotations: true})
+	if err != nil {
+		return MatchedRoute{}, err
+	}
+	if osrmRes.Code != "Ok" {
+		return MatchedRoute{}, MatchError{Code: osrmRes.Code, Reason: osrmRes.Reason()}
+	}
+	if len(osrmRes.Routes) == 0 {
+		return MatchedRoute{}, MatchError{Code: "NoRoute", Reason: "osrm returned no route"}
+	}
+	//the road exists for sure, whether the car really drove it is left to the interpolation flag
+	route := appendMatching(MatchedRoute{}, osrmRes.Routes[0], []SimulatorMessageData{from, to}, true)
+	route.Confidence = 1
+	return route, nil
+}
+
+//appendMatching appends the geometry, legs, distance and duration of a matching or route through the waypoints
+func appendMatching(route MatchedRoute, matching osrm.Matching, waypoints []SimulatorMessageData, interpolated bool) MatchedRoute {
+	for _, position := range matching.Geometry.Coordinates {
+		if len(position) < 2 {
+			continue
+		}
+		route.Route = append(route.Route, Coordinates{
+			Lat: position[1],
+			Lon: position[0],
+		})
+	}
+	for i, leg := range matching.Legs {
+		routeLeg := RouteLeg{
+			Distance:     leg.Distance,
+			Duration:     leg.Duration,
+			Roads:        osrmRoads(leg),
+			Interpolated: interpolated,
+		}
+		if i+1 < len(waypoints) {
+			routeLeg.Start = waypoints[i].Timestamp
+			routeLeg.End = waypoints[i+1].Timestamp
+		}
+		route.Legs = append(route.Legs, routeLeg)
+	}
+	route.Distance += matching.Distance
+	route.Duration += matching.Duration
+	return route
+}
+
+//osrmRoads groups the steps of the leg by road and assigns the annotated nodes to them
+func osrmRoads(leg osrm.Leg) []RoadSection {
+	var roads []RoadSection
+	var roadEnds []float64 // distance along the leg at which each road ends
+	for _, step := range leg.Steps {
+		if step.Distance == 0 && len(roads) > 0 {
+			//the arrive step has no length
+			continue
+		}
+		road := RoadSection{
+			Name:     step.Name,
+			Ref:      step.Ref,
+			Distance: step.Distance,
+			Duration: step.Duration,
+		}
+		for _, intersection := range step.Intersections {
+			for _, class := range intersection.Classes {
+				if !containsString(road.Classes, class) {
+					road.Classes = append(road.Classes, class)
+				}
+			}
+		}
+		merged := len(roads)
+		roads = appendRoad(roads, road)
+		if len(roads) > merged {
+			roadEnds = append(roadEnds, 0)
+		}
+		roadEnds[len(roadEnds)-1] = sumRoadDistances(roads)
+	}
+	if len(roads) == 0 {
+		return nil
+	}
+
+	//a segment between two annotated nodes belongs to the road its middle lies on
+	annotation := leg.Annotation
+	covered := 0.0
+	road := 0
+	for i := 0; i+1 < len(annotation.Nodes) && i < len(annotation.Distance); i++ {
+		middle := covered + annotation.Distance[i]/2
+		for road < len(roads)-1 && middle > roadEnds[road] {
+			road++
+		}
+		if i < len(annotation.Maxspeed) {
+			if maxSpeed, ok := annotation.Maxspeed[i].KilometersPerHour(); ok && maxSpeed > roads[road].MaxSpeed {
+				roads[road].MaxSpeed = maxSpeed
+			}
+		}
+		for _, nodeID := range annotation.Nodes[i : i+2] {
+			if n := len(roads[road].NodeIDs); n == 0 || roads[road].NodeIDs[n-1] != nodeID {
+				roads[road].NodeIDs = append(roads[road].NodeIDs, nodeID)
+			}
+		}
+		covered += annotation.Distance[i]
+	}
+	return roads
+}
+
+func sumRoadDistances(roads []RoadSection) float64 {
+	distance := 0.0
+	for _, road := range roads {
+		distance += road.Distance
+	}
+	return distance
+}
+
+//joinRoutes appends second to first, second has to start where first ends
+func joinRoutes(first MatchedRoute, second MatchedRoute) MatchedRoute {
+	if len(first.Route) == 0 {
+		second.Unmatched = append(first.Unmatched, second.Unmatched...)
+		return second
+	}
+	if len(second.Route) == 0 {
+		first.Unmatched = append(first.Unmatched, second.Unmatched...)
+		return first
+	}
+	joined := first
+	joined.Route = append(append([]Coordinates{}, first.Route...), second.Route[1:]...)
+	joined.Legs = append(append([]RouteLeg{}, first.Legs...), second.Legs...)
+	joined.Distance += second.Distance
+	joined.Duration += second.Duration
+	joined.Unmatched = append(append([]SimulatorMessageData{}, first.Unmatched...), second.Unmatched...)
+	if second.Confidence < joined.Confidence {
+		joined.Confidence = second.Confidence
+	}
+	return joined
+}
+
+//osrmOptions reads the osrm client configuration from the environment
+func osrmOptions() osrm.Options {
+	options := osrm.DefaultOptions
+	if timeout, err := time.ParseDuration(os.Getenv("OSRM_TIMEOUT")); err == nil {
+		options.Timeout = timeout
+	}
+	if retries, err := strconv.Atoi(os.Getenv("OSRM_RETRIES")); err == nil {
+		options.Retries = retries
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("OSRM_FAILURE_THRESHOLD")); err == nil {
+		options.FailureThreshold = threshold
+	}
+	if cooldown, err := time.ParseDuration(os.Getenv("OSRM_COOLDOWN")); err == nil {
+		options.Cooldown = cooldown
+	}
+	return options
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patreu22/go-clean/map-matcher/osrm"
+)
+
+//fakeOSRMMatcher answers match and route requests with fixed bodies
+func fakeOSRMMatcher(t *testing.T, match string, route string) *osrmMatcher {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/match/"):
+			w.Write([]byte(match))
+		case strings.HasPrefix(r.URL.Path, "/route/"):
+			w.Write([]byte(route))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	options := osrm.Options{Timeout: time.Second, FailureThreshold: 5, Cooldown: time.Minute}
+	return &osrmMatcher{client: osrm.NewClient([]string{server.URL}, options), profile: "driving"}
+}
+
+const twoMatchings = `{"code":"Ok",
+	"tracepoints":[
+		{"location":[13.40,52.50],"matchings_index":0,"waypoint_index":0},
+		{"location":[13.40,52.51],"matchings_index":0,"waypoint_index":1},
+		{"location":[13.42,52.51],"matchings_index":1,"waypoint_index":0},
+		{"location":[13.42,52.52],"matchings_index":1,"waypoint_index":1}],
+	"matchings":[
+		{"confidence":0.9,"distance":1100,"duration":80,"geometry":{"type":"LineString","coordinates":[[13.40,52.50],[13.40,52.51]]},
+		 "legs":[{"distance":1100,"duration":80,"steps":[{"distance":1100,"duration":80,"name":"Nord"}]}]},
+		{"confidence":0.6,"distance":1100,"duration":80,"geometry":{"type":"LineString","coordinates":[[13.42,52.51],[13.42,52.52]]},
+		 "legs":[{"distance":1100,"duration":80,"steps":[{"distance":1100,"duration":80,"name":"Ost"}]}]}]}`
+
+const gapRoute = `{"code":"Ok",
+	"routes":[
+		{"distance":2500,"duration":200,"geometry":{"type":"LineString","coordinates":[[13.40,52.51],[13.40,52.515],[13.42,52.515],[13.42,52.51]]},
+		 "legs":[{"distance":2500,"duration":200,"steps":[{"distance":2500,"duration":200,"name":"Umweg"}]}]}]}`
+
+func TestMatchRoutesGapBetweenMatchings(t *testing.T) {
+	matcher := fakeOSRMMatcher(t, twoMatchings, gapRoute)
+	at := func(minute int, lon float64, lat float64) SimulatorMessageData {
+		return SimulatorMessageData{Timestamp: testStart.Add(time.Duration(minute) * time.Minute).Format(time.RFC3339), Lon: lon, Lat: lat}
+	}
+	points := []SimulatorMessageData{at(0, 13.40, 52.50), at(1, 13.40, 52.51), at(5, 13.42, 52.51), at(6, 13.42, 52.52)}
+
+	route, err := matcher.Match(points)
+	if err != nil {
+		t.Fatalf("match failed: %v", err)
+	}
+
+	if len(route.Legs) != 3 {
+		t.Fatalf("got %d legs, want matching, gap and matching", len(route.Legs))
+	}
+	for i, want := range []struct {
+		road         string
+		interpolated bool
+		start, end   string
+	}{
+		{"Nord", false, points[0].Timestamp, points[1].Timestamp},
+		{"Umweg", true, points[1].Timestamp, points[2].Timestamp},
+		{"Ost", false, points[2].Timestamp, points[3].Timestamp},
+	} {
+		leg := route.Legs[i]
+		if len(leg.Roads) != 1 || leg.Roads[0].Name != want.road {
+			t.Errorf("leg %d: roads = %+v, want %s", i, leg.Roads, want.road)
+		}
+		if leg.Interpolated != want.interpolated {
+			t.Errorf("leg %d: interpolated = %v, want %v", i, leg.Interpolated, want.interpolated)
+		}
+		if leg.Start != want.start || leg.End != want.end {
+			t.Errorf("leg %d: runs %s to %s, want %s to %s", i, leg.Start, leg.End, want.start, want.end)
+		}
+	}
+
+	//the geometry follows the routed detour instead of jumping from the first matching to the second
+	wantRoute := []Coordinates{
+		{Lon: 13.40, Lat: 52.50}, {Lon: 13.40, Lat: 52.51}, {Lon: 13.40, Lat: 52.515},
+		{Lon: 13.42, Lat: 52.515}, {Lon: 13.42, Lat: 52.51}, {Lon: 13.42, Lat: 52.52},
+	}
+	if len(route.Route) != len(wantRoute) {
+		t.Fatalf("route = %v, want %v", route.Route, wantRoute)
+	}
+	for i := range wantRoute {
+		if route.Route[i] != wantRoute[i] {
+			t.Errorf("route[%d] = %v, want %v", i, route.Route[i], wantRoute[i])
+		}
+	}
+	if route.Distance != 4700 || route.Duration != 360 {
+		t.Errorf("distance %v duration %v, want 4700 and 360", route.Distance, route.Duration)
+	}
+	if route.Confidence != 0.6 {
+		t.Errorf("confidence = %v, want the weakest matching 0.6", route.Confidence)
+	}
+}
+
+const annotatedMatching = `{"code":"Ok",
+	"tracepoints":[
+		{"location":[13.40,52.50],"matchings_index":0,"waypoint_index":0},
+		{"location":[13.40,52.51],"matchings_index":0,"waypoint_index":1},
+		{"location":[13.41,52.51],"matchings_index":0,"waypoint_index":2}],
+	"matchings":[
+		{"confidence":0.8,"distance":1100,"duration":115,"geometry":{"type":"LineString","coordinates":[[13.40,52.50],[13.40,52.51],[13.41,52.51]]},
+		 "legs":[
+			{"distance":900,"duration":90,
+			 "steps":[
+				{"distance":300,"duration":30,"name":"Hauptstraße","ref":"B 1","intersections":[{"classes":["tunnel"]}]},
+				{"distance":200,"duration":20,"name":"Hauptstraße","ref":"B 1","intersections":[{"classes":["tunnel"]},{}]},
+				{"distance":400,"duration":40,"name":"Nebenweg"},
+				{"distance":0,"duration":0,"name":"Nebenweg"}],
+			 "annotation":{"nodes":[1,2,3,4,5],"distance":[250,240,30,380],"duration":[25,24,3,38],
+				"maxspeed":[{"speed":50,"unit":"km/h"},{"unknown":true},{"speed":30,"unit":"mph"},{"none":true}]}},
+			{"distance":200,"duration":25,
+			 "steps":[
+				{"distance":200,"duration":25,"name":"Nebenweg"},
+				{"distance":0,"duration":0,"name":"Nebenweg"}],
+			 "annotation":{"nodes":[5,6,7],"distance":[120,80],"duration":[15,10],"maxspeed":[{"none":true},{"none":true}]}}]}]}`
+
+func TestMatchSplitsLegsIntoRoads(t *testing.T) {
+	matcher := fakeOSRMMatcher(t, annotatedMatching, `{"code":"NoRoute"}`)
+	points := []SimulatorMessageData{{Lon: 13.40, Lat: 52.50}, {Lon: 13.40, Lat: 52.51}, {Lon: 13.41, Lat: 52.51}}
+
+	route, err := matcher.Match(points)
+	if err != nil {
+		t.Fatalf("match failed: %v", err)
+	}
+
+	//osrm annotates nodes but not ways, so the way id stays empty; the segment from node 3 to 4 has its
+	//middle past the 500m the steps give the Hauptstraße and belongs to the Nebenweg
+	want := [][]RoadSection{
+		{
+			{Name: "Hauptstraße", Ref: "B 1", NodeIDs: []int64{1, 2, 3}, Classes: []string{"tunnel"}, MaxSpeed: 50, Distance: 500, Duration: 50},
+			{Name: "Nebenweg", NodeIDs: []int64{3, 4, 5}, MaxSpeed: 30 * 1.609344, Distance: 400, Duration: 40},
+		},
+		{
+			{Name: "Nebenweg", NodeIDs: []int64{5, 6, 7}, Distance: 200, Duration: 25},
+		},
+	}
+	if len(route.Legs) != len(want) {
+		t.Fatalf("got %d legs, want %d", len(route.Legs), len(want))
+	}
+	for i, roads := range want {
+		if !reflect.DeepEqual(route.Legs[i].Roads, roads) {
+			t.Errorf("leg %d: roads = %+v, want %+v", i, route.Legs[i].Roads, roads)
+		}
+	}
+}
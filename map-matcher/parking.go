@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+var (
+	parkedQueueName    = "vehicle.parked"
+	stationaryDistance = 15.0            // meters a car may seem to move while standing, raised to the accuracy of the report
+	parkedMinDuration  = 2 * time.Minute // shorter stops like traffic lights are skipped without an event
+)
+
+//parking is a period in which a car kept reporting without moving
+type parking struct {
+	Start  SimulatorMessageData // last point before the car stopped, where it stands
+	End    SimulatorMessageData // last report while standing
+	Points int                  // reports skipped while standing
+}
+
+func (p parking) dwell() time.Duration {
+	start, end, err := pointTimes(p.Start, p.End)
+	if err != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+//stationary reports whether the point lies within the noise of where the car stood or was last seen
+func (c *carState) stationary(point SimulatorMessageData) bool {
+	reference := c.Trip.End
+	if c.Parking != nil {
+		reference = c.Parking.Start
+	}
+	threshold := math.Max(stationaryDistance, point.Accuracy)
+	return Distance(reference.Lat, reference.Lon, point.Lat, point.Lon) <= threshold
+}
+
+//park records the point as a report of the standing car instead of buffering it for matching
+func (c *carState) park(point SimulatorMessageData) {
+	if c.Parking == nil {
+		c.Parking = &parking{Start: c.Trip.End}
+	}
+	c.Parking.End = point
+	c.Parking.Points++
+}
+
+//unpark ends the parking of the car, it is returned if the car stood long enough to count as parked
+func (c *carState) unpark() *parking {
+	parked := c.Parking
+	c.Parking = nil
+	if parked == nil || parked.dwell() < parkedMinDuration {
+		return nil
+	}
+	return parked
+}
+
+//ParkedMessageData reports a car which stood at the same position from dwell start to dwell end
+type ParkedMessageData struct {
+	MessageID  int         `json:"messageId"`
+	CarID      string      `json:"carId"`
+	TripID     string      `json:"tripId"`
+	Timestamp  string      `json:"timestamp"`
+	DwellStart string      `json:"dwellStart"`
+	DwellEnd   string      `json:"dwellEnd"`
+	Dwell      float64     `json:"dwell"` // seconds
+	Position   Coordinates `json:"position"`
+	Points     int         `json:"points"` // reports received while parked, none of them was matched
+	Sender     string      `json:"sender"`
+	Topic      string      `json:"topic"`
+}
+
+//ParkedMessage published on vehicle.parked
+type ParkedMessage struct {
+	Data ParkedMessageData `json:"data"`
+}
+
+func (p ParkedMessage) toString() string {
+	return fmt.Sprintf("%+v\n", p)
+}
+
+func publishParkedMessage(parked parking) {
+	msg := ParkedMessage{
+		Data: ParkedMessageData{
+			Sender:     "GoMicro-MapMatcher",
+			Topic:      parkedQueueName,
+			MessageID:  parked.End.MessageID,
+			CarID:      parked.Start.CarID,
+			TripID:     parked.Start.TripID,
+			Timestamp:  time.Now().Local().Format(time.RFC3339),
+			DwellStart: parked.Start.Timestamp,
+			DwellEnd:   parked.End.Timestamp,
+			Dwell:      parked.dwell().Seconds(),
+			Position:   Coordinates{Lat: parked.Start.Lat, Lon: parked.Start.Lon},
+			Points:     parked.Points,
+		},
+	}
+
+	parkedOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(parkedQueueName, parkedOutput)
+	logMessage(msg.Data.MessageID, "parked")
+	fmt.Println("---published parked message---\n" + msg.toString())
+}
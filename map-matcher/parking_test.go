@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+//standing returns n reports of a car standing where point is, one every 10 seconds after it
+func standing(point SimulatorMessageData, n int) []SimulatorMessageData {
+	start, _ := time.Parse(time.RFC3339, point.Timestamp)
+	points := make([]SimulatorMessageData, 0, n)
+	for i := 1; i <= n; i++ {
+		standing := point
+		standing.MessageID = point.MessageID + i
+		standing.Timestamp = start.Add(time.Duration(i) * 10 * time.Second).Format(time.RFC3339)
+		points = append(points, standing)
+	}
+	return points
+}
+
+//drivingOff returns the report 10 seconds after point, 100m further north
+func drivingOff(point SimulatorMessageData) SimulatorMessageData {
+	start, _ := time.Parse(time.RFC3339, point.Timestamp)
+	point.MessageID++
+	point.Timestamp = start.Add(10 * time.Second).Format(time.RFC3339)
+	point.Lat += 0.0009
+	return point
+}
+
+//parkingStore returns a store without smoothing, which would drag the position of a stopping car along
+func parkingStore(t *testing.T) *carStore {
+	noise := kalmanProcessNoise
+	kalmanProcessNoise = 0
+	t.Cleanup(func() { kalmanProcessNoise = noise })
+	store, err := newCarStore(3, time.Minute, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestParking(t *testing.T) {
+	cases := []struct {
+		name     string
+		standing int // reports while standing
+		parked   bool
+		ended    string // reason the trip ended on driving off
+	}{
+		{"traffic light", 6, false, ""},
+		{"just below the dwell threshold", 11, false, ""},
+		{"at the dwell threshold", 12, true, ""},
+		{"delivery stop", 30, true, ""},
+		{"parked over night", 100, true, "parked"},
+	}
+	for _, c := range cases {
+		store := parkingStore(t)
+		route := drive("car-1", 4)
+		for _, point := range route {
+			store.push(point, "", testStart)
+		}
+		arrived := route[len(route)-1]
+		stops := standing(arrived, c.standing)
+		for i, point := range stops {
+			update := store.push(point, "", testStart)
+			if update.Parked != nil || len(update.Batches) > 0 || update.Ended != nil {
+				t.Errorf("%s: standing report %d gave %+v", c.name, i, update)
+			}
+			if i == 0 {
+				state := store.cars["car-1"]
+				if state.Parking == nil || state.Parking.Start.MessageID != arrived.MessageID {
+					t.Errorf("%s: first standing report did not start a parking at the point of arrival: %+v", c.name, state.Parking)
+				}
+			}
+		}
+		left := stops[len(stops)-1]
+
+		update := store.push(drivingOff(left), "", testStart)
+		if store.cars["car-1"].Parking != nil {
+			t.Errorf("%s: car is still parked after driving off", c.name)
+		}
+		if c.parked != (update.Parked != nil) {
+			t.Errorf("%s: parked = %+v, want parked %v", c.name, update.Parked, c.parked)
+		}
+		if update.Parked != nil {
+			if update.Parked.Start.MessageID != arrived.MessageID || update.Parked.End.MessageID != left.MessageID || update.Parked.Points != c.standing {
+				t.Errorf("%s: parked from %d to %d with %d reports, want %d to %d with %d", c.name,
+					update.Parked.Start.MessageID, update.Parked.End.MessageID, update.Parked.Points, arrived.MessageID, left.MessageID, c.standing)
+			}
+			if dwell := update.Parked.dwell(); dwell != time.Duration(c.standing)*10*time.Second {
+				t.Errorf("%s: dwell = %v", c.name, dwell)
+			}
+		}
+		ended := ""
+		if update.Ended != nil {
+			ended = update.Ended.Reason
+		}
+		if ended != c.ended {
+			t.Errorf("%s: trip ended as %q, want %q", c.name, ended, c.ended)
+		}
+	}
+}
+
+func TestParkedCarIsUnparkedOnEviction(t *testing.T) {
+	store := parkingStore(t)
+	route := drive("car-1", 4)
+	for _, point := range route {
+		store.push(point, "", testStart)
+	}
+	arrived := route[len(route)-1]
+	for _, point := range standing(arrived, 20) {
+		store.push(point, "", testStart)
+	}
+
+	updates := store.evict(testStart.Add(2 * time.Minute))
+	if len(updates) != 1 {
+		t.Fatalf("%d updates on eviction, want 1", len(updates))
+	}
+	parked := updates[0].Parked
+	if parked == nil || parked.Start.MessageID != arrived.MessageID || parked.Points != 20 {
+		t.Errorf("parked = %+v, want the 20 reports standing at point %d", parked, arrived.MessageID)
+	}
+	if updates[0].Ended == nil || updates[0].Ended.Reason != "idle" {
+		t.Errorf("ended = %+v, want the trip ended as idle", updates[0].Ended)
+	}
+	//the standing reports were never buffered for matching
+	if len(updates[0].Batches) != 1 || messageIDs(updates[0].Batches[0])[1] != arrived.MessageID {
+		t.Errorf("leftover batches = %v, want the one up to the point of arrival", updates[0].Batches)
+	}
+}
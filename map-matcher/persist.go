@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var carStateBucket = []byte("cars")
+
+//carStatePersistence keeps the car states on disk so matching continues after a restart
+type carStatePersistence interface {
+	write(states map[string][]byte) error // json encoded states, nil removes the car
+	load() (map[string]*carState, error)
+}
+
+//writeBehind writes the car states in the background, reporting cars never wait for the disk. Only the latest
+//state of a car is kept until the next write, all pending cars are written in one transaction.
+type writeBehind struct {
+	mutex       sync.Mutex
+	writing     sync.Mutex // held during a write, a flush returns once everything queued before is on disk
+	pending     map[string][]byte
+	signal      chan struct{}
+	persistence carStatePersistence
+}
+
+func newWriteBehind(persistence carStatePersistence) *writeBehind {
+	w := &writeBehind{
+		pending:     make(map[string][]byte),
+		signal:      make(chan struct{}, 1),
+		persistence: persistence,
+	}
+	go w.run()
+	return w
+}
+
+//queue replaces the pending state of the car, a nil state removes it
+func (w *writeBehind) queue(carID string, state *carState) {
+	var value []byte
+	if state != nil {
+		var err error
+		if value, err = json.Marshal(state); err != nil {
+			fmt.Println("---car state persistence error---")
+			fmt.Println(err)
+			return
+		}
+	}
+	w.mutex.Lock()
+	w.pending[carID] = value
+	w.mutex.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *writeBehind) run() {
+	for range w.signal {
+		w.flush()
+	}
+}
+
+//flush writes the pending states, the store keeps working in memory if this fails
+func (w *writeBehind) flush() {
+	w.writing.Lock()
+	defer w.writing.Unlock()
+	w.mutex.Lock()
+	pending := w.pending
+	w.pending = make(map[string][]byte)
+	w.mutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if err := w.persistence.write(pending); err != nil {
+		fmt.Println("---car state persistence error---")
+		fmt.Println(err)
+	}
+}
+
+//boltPersistence stores every car state as json in a local bbolt file
+type boltPersistence struct {
+	db *bolt.DB
+}
+
+func newBoltPersistence(path string) (*boltPersistence, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(carStateBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltPersistence{db: db}, nil
+}
+
+func (p *boltPersistence) write(states map[string][]byte) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(carStateBucket)
+		for carID, value := range states {
+			var err error
+			if value == nil {
+				err = bucket.Delete([]byte(carID))
+			} else {
+				err = bucket.Put([]byte(carID), value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *boltPersistence) load() (map[string]*carState, error) {
+	cars := make(map[string]*carState)
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(carStateBucket).ForEach(func(carID []byte, value []byte) error {
+			var state carState
+			if err := json.Unmarshal(value, &state); err != nil {
+				return err
+			}
+			cars[string(carID)] = &state
+			return nil
+		})
+	})
+	return cars, err
+}
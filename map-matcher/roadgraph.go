@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+
+	"github.com/qedus/osmpbf"
+)
+
+const gridCellSize = 0.002 // degrees, roughly 140-220 m around Berlin
+
+var (
+	//highwaySpeeds are the roads each vehicle type may use with the speed in km/h assumed when the way has no maxspeed
+	highwaySpeeds = map[string]map[string]float64{
+		"car": {
+			"motorway":       100,
+			"motorway_link":  60,
+			"trunk":          80,
+			"trunk_link":     50,
+			"primary":        50,
+			"primary_link":   40,
+			"secondary":      50,
+			"secondary_link": 40,
+			"tertiary":       40,
+			"tertiary_link":  30,
+			"unclassified":   30,
+			"residential":    30,
+			"living_street":  7,
+			"service":        15,
+			"road":           30,
+		},
+		"truck": {
+			"motorway":       80,
+			"motorway_link":  50,
+			"trunk":          70,
+			"trunk_link":     45,
+			"primary":        50,
+			"primary_link":   40,
+			"secondary":      45,
+			"secondary_link": 35,
+			"tertiary":       35,
+			"tertiary_link":  25,
+			"unclassified":   25,
+			"residential":    25,
+			"service":        10,
+			"road":           25,
+		},
+		"cargo_bike": {
+			"primary":        15,
+			"primary_link":   15,
+			"secondary":      15,
+			"secondary_link": 15,
+			"tertiary":       15,
+			"tertiary_link":  15,
+			"unclassified":   15,
+			"residential":    15,
+			"living_street":  10,
+			"service":        12,
+			"road":           12,
+			"cycleway":       15,
+			"track":          10,
+		},
+	}
+	//accessTags are checked from the most specific to the most general, the first one set decides
+	accessTags = map[string][]string{
+		"car":        {"motorcar", "motor_vehicle", "vehicle", "access"},
+		"truck":      {"hgv", "motor_vehicle", "vehicle", "access"},
+		"cargo_bike": {"bicycle", "vehicle", "access"},
+	}
+	maxVehicleSpeeds = map[string]float64{
+		"truck":      80,
+		"cargo_bike": 20,
+	}
+)
+
+//roadNode is a junction or shape point of the road network
+type roadNode struct {
+	ID  int64
+	Lat float64
+	Lon float64
+}
+
+//roadEdge is a directed road segment between two consecutive nodes of a way
+type roadEdge struct {
+	from   int32
+	to     int32
+	length float64 // meters
+	way    int32   // index into roadGraph.ways
+}
+
+//roadWay holds the attributes of the osm way an edge belongs to
+type roadWay struct {
+	id       int64
+	name     string
+	highway  string
+	speed    float64 // km/h
+	maxSpeed float64 // tagged speed limit in km/h, 0 if not tagged
+}
+
+type gridCell struct {
+	x int32
+	y int32
+}
+
+//roadGraph is the drivable road network of an osm extract with a grid index over its edges
+type roadGraph struct {
+	nodes    []roadNode
+	edges    []roadEdge
+	outgoing [][]int32 // edge indexes leaving each node
+	ways     []roadWay
+	grid     map[gridCell][]int32
+}
+
+//loadRoadGraph reads all ways of an .osm.pbf extract the vehicle type may use, ways are read in a first pass
+//so that only the nodes used by roads have to be kept in the second one
+func loadRoadGraph(path string, vehicleType string) (*roadGraph, error) {
+	type osmWay struct {
+		nodeIDs []int64
+		oneway  int // 1 forward only, -1 backward only, 0 both directions
+	}
+	graph := &roadGraph{grid: make(map[gridCell][]int32)}
+	nodeIndexes := make(map[int64]int32)
+	var osmWays []osmWay
+
+	err := decodePBF(path, func(v interface{}) {
+		way, ok := v.(*osmpbf.Way)
+		if !ok {
+			return
+		}
+		speed, ok := wayAccess(way.Tags, vehicleType)
+		if !ok {
+			return
+		}
+		maxSpeed, _ := parseMaxspeed(way.Tags["maxspeed"])
+		for _, id := range way.NodeIDs {
+			nodeIndexes[id] = -1
+		}
+		graph.ways = append(graph.ways, roadWay{
+			id:       way.ID,
+			name:     way.Tags["name"],
+			highway:  way.Tags["highway"],
+			speed:    speed,
+			maxSpeed: maxSpeed,
+		})
+		osmWays = append(osmWays, osmWay{
+			nodeIDs: way.NodeIDs,
+			oneway:  onewayDirection(way.Tags, vehicleType),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = decodePBF(path, func(v interface{}) {
+		node, ok := v.(*osmpbf.Node)
+		if !ok {
+			return
+		}
+		if index, ok := nodeIndexes[node.ID]; ok && index < 0 {
+			nodeIndexes[node.ID] = int32(len(graph.nodes))
+			graph.nodes = append(graph.nodes, roadNode{ID: node.ID, Lat: node.Lat, Lon: node.Lon})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	graph.outgoing = make([][]int32, len(graph.nodes))
+	for wayIndex, way := range osmWays {
+		for i := 1; i < len(way.nodeIDs); i++ {
+			from, to := nodeIndexes[way.nodeIDs[i-1]], nodeIndexes[way.nodeIDs[i]]
+			if from < 0 || to < 0 {
+				//node is missing in the extract, the way was clipped at its border
+				continue
+			}
+			if way.oneway >= 0 {
+				graph.addEdge(from, to, int32(wayIndex))
+			}
+			if way.oneway <= 0 {
+				graph.addEdge(to, from, int32(wayIndex))
+			}
+		}
+	}
+	fmt.Printf("--- Loaded %s road graph with %d nodes and %d edges from %s ---\n", vehicleType, len(graph.nodes), len(graph.edges), path)
+	return graph, nil
+}
+
+//decodePBF calls handle for every node, way and relation of the extract
+func decodePBF(path string, handle func(interface{})) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := osmpbf.NewDecoder(file)
+	decoder.SetBufferSize(osmpbf.MaxBlobSize)
+	if err := decoder.Start(runtime.GOMAXPROCS(-1)); err != nil {
+		return err
+	}
+	for {
+		v, err := decoder.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle(v)
+	}
+}
+
+//wayAccess returns whether the vehicle type may use the way and the speed it drives there in km/h
+func wayAccess(tags map[string]string, vehicleType string) (float64, bool) {
+	if tags["highway"] == "" {
+		return 0, false
+	}
+	speed, ok := highwaySpeeds[vehicleType][tags["highway"]]
+	for _, tag := range accessTags[vehicleType] {
+		value, set := tags[tag]
+		if !set {
+			continue
+		}
+		switch value {
+		case "no", "private", "agricultural", "forestry":
+			return 0, false
+		case "yes", "designated", "permissive", "destination", "delivery":
+			//explicitly allowed, e.g. bicycle=yes on a footway
+			if !ok {
+				speed, ok = highwaySpeeds[vehicleType]["service"], true
+			}
+		}
+		break
+	}
+	if !ok {
+		return 0, false
+	}
+	if maxspeed, err := parseMaxspeed(tags["maxspeed"]); err == nil && vehicleType != "cargo_bike" {
+		speed = maxspeed
+	}
+	if maxSpeed, ok := maxVehicleSpeeds[vehicleType]; ok && speed > maxSpeed {
+		speed = maxSpeed
+	}
+	return speed, true
+}
+
+//onewayDirection returns 1 if the way may only be driven in node order, -1 if only against it
+func onewayDirection(tags map[string]string, vehicleType string) int {
+	if vehicleType == "cargo_bike" && tags["oneway:bicycle"] == "no" {
+		return 0
+	}
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		return 1
+	case "-1", "reverse":
+		return -1
+	case "no", "false", "0":
+		return 0
+	}
+	if tags["highway"] == "motorway" || tags["highway"] == "motorway_link" || tags["junction"] == "roundabout" {
+		return 1
+	}
+	return 0
+}
+
+//parseMaxspeed parses numeric maxspeed tags in km/h or mph
+func parseMaxspeed(maxspeed string) (float64, error) {
+	var speed float64
+	var unit string
+	n, err := fmt.Sscanf(maxspeed, "%g %s", &speed, &unit)
+	if n == 0 {
+		return 0, err
+	}
+	if unit == "mph" {
+		speed *= 1.609344
+	}
+	if speed <= 0 {
+		return 0, fmt.Errorf("invalid maxspeed %q", maxspeed)
+	}
+	return speed, nil
+}
+
+func (g *roadGraph) addEdge(from int32, to int32, way int32) {
+	edgeIndex := int32(len(g.edges))
+	a, b := g.nodes[from], g.nodes[to]
+	g.edges = append(g.edges, roadEdge{
+		from:   from,
+		to:     to,
+		length: Distance(a.Lat, a.Lon, b.Lat, b.Lon),
+		way:    way,
+	})
+	g.outgoing[from] = append(g.outgoing[from], edgeIndex)
+
+	//register the edge in every cell its bounding box touches
+	minCell, maxCell := g.cellOf(math.Min(a.Lat, b.Lat), math.Min(a.Lon, b.Lon)), g.cellOf(math.Max(a.Lat, b.Lat), math.Max(a.Lon, b.Lon))
+	for x := minCell.x; x <= maxCell.x; x++ {
+		for y := minCell.y; y <= maxCell.y; y++ {
+			cell := gridCell{x: x, y: y}
+			g.grid[cell] = append(g.grid[cell], edgeIndex)
+		}
+	}
+}
+
+func (g *roadGraph) cellOf(lat float64, lon float64) gridCell {
+	return gridCell{
+		x: int32(math.Floor(lon / gridCellSize)),
+		y: int32(math.Floor(lat / gridCellSize)),
+	}
+}
+
+//nearbyEdges returns the indexes of all edges in the grid cells within radius meters around the position
+func (g *roadGraph) nearbyEdges(lat float64, lon float64, radius float64) []int32 {
+	latRadius := radius / 111320
+	lonRadius := radius / (111320 * math.Cos(lat*math.Pi/180))
+	minCell, maxCell := g.cellOf(lat-latRadius, lon-lonRadius), g.cellOf(lat+latRadius, lon+lonRadius)
+
+	seen := make(map[int32]bool)
+	var edges []int32
+	for x := minCell.x; x <= maxCell.x; x++ {
+		for y := minCell.y; y <= maxCell.y; y++ {
+			for _, edge := range g.grid[gridCell{x: x, y: y}] {
+				if !seen[edge] {
+					seen[edge] = true
+					edges = append(edges, edge)
+				}
+			}
+		}
+	}
+	return edges
+}
+
+//project returns the closest position on the edge and its fraction along the edge
+func (g *roadGraph) project(edgeIndex int32, lat float64, lon float64) (Coordinates, float64) {
+	edge := g.edges[edgeIndex]
+	a, b := g.nodes[edge.from], g.nodes[edge.to]
+
+	//equirectangular projection is precise enough for the length of a road segment
+	scale := math.Cos(lat * math.Pi / 180)
+	ax, ay := a.Lon*scale, a.Lat
+	bx, by := b.Lon*scale, b.Lat
+	px, py := lon*scale, lat
+
+	fraction := 0.0
+	if lengthSquared := (bx-ax)*(bx-ax) + (by-ay)*(by-ay); lengthSquared > 0 {
+		fraction = ((px-ax)*(bx-ax) + (py-ay)*(by-ay)) / lengthSquared
+		fraction = math.Max(0, math.Min(1, fraction))
+	}
+	return Coordinates{
+		Lat: a.Lat + (b.Lat-a.Lat)*fraction,
+		Lon: a.Lon + (b.Lon-a.Lon)*fraction,
+	}, fraction
+}
+
+//road returns the section driven on the fraction of the edge
+func (g *roadGraph) road(edgeIndex int32, fraction float64) RoadSection {
+	edge := g.edges[edgeIndex]
+	way := g.ways[edge.way]
+	return RoadSection{
+		Name:      way.name,
+		WayID:     way.id,
+		NodeIDs:   []int64{g.nodes[edge.from].ID, g.nodes[edge.to].ID},
+		RoadClass: way.highway,
+		MaxSpeed:  way.maxSpeed,
+		Distance:  edge.length * fraction,
+		Duration:  g.edgeSeconds(edgeIndex, fraction),
+	}
+}
+
+//edgeSeconds returns the time needed to drive the fraction of the edge
+func (g *roadGraph) edgeSeconds(edgeIndex int32, fraction float64) float64 {
+	edge := g.edges[edgeIndex]
+	return edge.length * fraction / (g.ways[edge.way].speed / 3.6)
+}
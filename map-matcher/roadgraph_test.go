@@ -0,0 +1,262 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+//testWay is an osm way of the hand built test network
+type testWay struct {
+	id      int64
+	nodeIDs []int64
+	tags    map[string]string
+}
+
+//testNodes are two parallel roads 45m apart, joined at both ends. The Parallelweg is a oneway to the west.
+var testNodes = map[int64]Coordinates{
+	1:  {Lat: 52.5, Lon: 13.400},
+	2:  {Lat: 52.5, Lon: 13.405},
+	3:  {Lat: 52.5, Lon: 13.410},
+	11: {Lat: 52.5004, Lon: 13.400},
+	12: {Lat: 52.5004, Lon: 13.405},
+	13: {Lat: 52.5004, Lon: 13.410},
+	21: {Lat: 52.499, Lon: 13.405},
+}
+
+var testWays = []testWay{
+	{100, []int64{1, 2, 3}, map[string]string{"highway": "primary", "name": "Hauptstraße", "maxspeed": "50"}},
+	{101, []int64{11, 12, 13}, map[string]string{"highway": "residential", "name": "Parallelweg", "oneway": "-1"}},
+	{102, []int64{1, 11}, map[string]string{"highway": "residential", "name": "Westweg"}},
+	{103, []int64{3, 13}, map[string]string{"highway": "residential", "name": "Ostweg"}},
+	{104, []int64{2, 21}, map[string]string{"highway": "residential", "name": "Lieferweg", "hgv": "no"}},
+}
+
+//testRoadGraph builds the test network for the vehicle type like loadRoadGraph does from an extract
+func testRoadGraph(vehicleType string) *roadGraph {
+	graph := &roadGraph{grid: make(map[gridCell][]int32)}
+	nodeIndexes := make(map[int64]int32)
+	for _, way := range testWays {
+		speed, ok := wayAccess(way.tags, vehicleType)
+		if !ok {
+			continue
+		}
+		maxSpeed, _ := parseMaxspeed(way.tags["maxspeed"])
+		graph.ways = append(graph.ways, roadWay{id: way.id, name: way.tags["name"], highway: way.tags["highway"], speed: speed, maxSpeed: maxSpeed})
+		for _, id := range way.nodeIDs {
+			if _, ok := nodeIndexes[id]; !ok {
+				nodeIndexes[id] = int32(len(graph.nodes))
+				graph.nodes = append(graph.nodes, roadNode{ID: id, Lat: testNodes[id].Lat, Lon: testNodes[id].Lon})
+				graph.outgoing = append(graph.outgoing, nil)
+			}
+		}
+	}
+	wayIndex := int32(0)
+	for _, way := range testWays {
+		if _, ok := wayAccess(way.tags, vehicleType); !ok {
+			continue
+		}
+		oneway := onewayDirection(way.tags, vehicleType)
+		for i := 1; i < len(way.nodeIDs); i++ {
+			from, to := nodeIndexes[way.nodeIDs[i-1]], nodeIndexes[way.nodeIDs[i]]
+			if oneway >= 0 {
+				graph.addEdge(from, to, wayIndex)
+			}
+			if oneway <= 0 {
+				graph.addEdge(to, from, wayIndex)
+			}
+		}
+		wayIndex++
+	}
+	return graph
+}
+
+//testEdge returns the index of the edge between the osm nodes
+func testEdge(t *testing.T, graph *roadGraph, from int64, to int64) int32 {
+	for i, edge := range graph.edges {
+		if graph.nodes[edge.from].ID == from && graph.nodes[edge.to].ID == to {
+			return int32(i)
+		}
+	}
+	t.Fatalf("no edge from node %d to %d", from, to)
+	return -1
+}
+
+//testCandidate places a candidate at the fraction of the edge between the osm nodes
+func testCandidate(t *testing.T, graph *roadGraph, from int64, to int64, fraction float64) hmmCandidate {
+	edge := testEdge(t, graph, from, to)
+	a, b := graph.nodes[graph.edges[edge].from], graph.nodes[graph.edges[edge].to]
+	return hmmCandidate{
+		edge:     edge,
+		fraction: fraction,
+		position: Coordinates{Lat: a.Lat + (b.Lat-a.Lat)*fraction, Lon: a.Lon + (b.Lon-a.Lon)*fraction},
+	}
+}
+
+func TestWayAccess(t *testing.T) {
+	cases := []struct {
+		name        string
+		tags        map[string]string
+		vehicleType string
+		speed       float64
+		ok          bool
+	}{
+		{"car on primary", map[string]string{"highway": "primary"}, "car", 50, true},
+		{"maxspeed overrides the default", map[string]string{"highway": "residential", "maxspeed": "20"}, "car", 20, true},
+		{"truck is capped at its own limit", map[string]string{"highway": "motorway", "maxspeed": "120"}, "truck", 80, true},
+		{"road closed to trucks", map[string]string{"highway": "residential", "hgv": "no"}, "truck", 0, false},
+		{"road closed to trucks is open to cars", map[string]string{"highway": "residential", "hgv": "no"}, "car", 30, true},
+		{"hgv tag wins over vehicle", map[string]string{"highway": "residential", "hgv": "delivery", "vehicle": "no"}, "truck", 25, true},
+		{"private road", map[string]string{"highway": "service", "access": "private"}, "car", 0, false},
+		{"footway opened to bikes", map[string]string{"highway": "footway", "bicycle": "yes"}, "cargo_bike", 12, true},
+		{"footway", map[string]string{"highway": "footway"}, "cargo_bike", 0, false},
+		{"cargo bike ignores maxspeed", map[string]string{"highway": "residential", "maxspeed": "50"}, "cargo_bike", 15, true},
+		{"no highway", map[string]string{"railway": "rail"}, "car", 0, false},
+	}
+	for _, c := range cases {
+		speed, ok := wayAccess(c.tags, c.vehicleType)
+		if speed != c.speed || ok != c.ok {
+			t.Errorf("%s: wayAccess = %v, %v, want %v, %v", c.name, speed, ok, c.speed, c.ok)
+		}
+	}
+}
+
+func TestOnewayDirection(t *testing.T) {
+	cases := []struct {
+		name        string
+		tags        map[string]string
+		vehicleType string
+		direction   int
+	}{
+		{"two way street", map[string]string{"highway": "residential"}, "car", 0},
+		{"oneway street", map[string]string{"highway": "residential", "oneway": "yes"}, "car", 1},
+		{"oneway against the node order", map[string]string{"highway": "residential", "oneway": "-1"}, "truck", -1},
+		{"motorway is implicitly oneway", map[string]string{"highway": "motorway"}, "car", 1},
+		{"roundabout is implicitly oneway", map[string]string{"highway": "primary", "junction": "roundabout"}, "car", 1},
+		{"explicit two way motorway", map[string]string{"highway": "motorway", "oneway": "no"}, "car", 0},
+		{"oneway open to bikes both ways", map[string]string{"highway": "residential", "oneway": "yes", "oneway:bicycle": "no"}, "cargo_bike", 0},
+		{"bike exception does not apply to cars", map[string]string{"highway": "residential", "oneway": "yes", "oneway:bicycle": "no"}, "car", 1},
+	}
+	for _, c := range cases {
+		if direction := onewayDirection(c.tags, c.vehicleType); direction != c.direction {
+			t.Errorf("%s: onewayDirection = %d, want %d", c.name, direction, c.direction)
+		}
+	}
+}
+
+func TestParseMaxspeed(t *testing.T) {
+	cases := []struct {
+		maxspeed string
+		speed    float64
+		ok       bool
+	}{
+		{"50", 50, true},
+		{"30 km/h", 30, true},
+		{"50 mph", 50 * 1.609344, true},
+		{"none", 0, false},
+		{"DE:urban", 0, false},
+		{"signals", 0, false},
+		{"0", 0, false},
+		{"", 0, false},
+	}
+	for _, c := range cases {
+		speed, err := parseMaxspeed(c.maxspeed)
+		if speed != c.speed || (err == nil) != c.ok {
+			t.Errorf("parseMaxspeed(%q) = %v, %v, want %v and ok %v", c.maxspeed, speed, err, c.speed, c.ok)
+		}
+	}
+}
+
+func TestRoadGraphEdges(t *testing.T) {
+	car, truck := testRoadGraph("car"), testRoadGraph("truck")
+
+	//the oneway only has edges against its node order
+	testEdge(t, car, 13, 12)
+	testEdge(t, car, 12, 11)
+	for _, edge := range car.edges {
+		if from, to := car.nodes[edge.from].ID, car.nodes[edge.to].ID; (from == 11 && to == 12) || (from == 12 && to == 13) {
+			t.Errorf("edge from %d to %d runs the wrong way up the oneway", from, to)
+		}
+	}
+	//the road closed to trucks is only in the car graph
+	testEdge(t, car, 2, 21)
+	for _, way := range truck.ways {
+		if way.name == "Lieferweg" {
+			t.Errorf("road closed to trucks is in the truck graph")
+		}
+	}
+	if edges := len(car.edges); edges != 12 {
+		t.Errorf("car graph has %d edges, want 12", edges)
+	}
+}
+
+func TestShortestPaths(t *testing.T) {
+	graph := testRoadGraph("car")
+	length := func(from int64, to int64) float64 {
+		return graph.edges[testEdge(t, graph, from, to)].length
+	}
+
+	//from the middle of the western Hauptstraße to the middle of the eastern Parallelweg the oneway
+	//forces the car around the eastern end
+	source := testCandidate(t, graph, 1, 2, 0.5)
+	target := testCandidate(t, graph, 13, 12, 0.5)
+	behind := testCandidate(t, graph, 1, 2, 0.25)
+	ahead := testCandidate(t, graph, 1, 2, 0.75)
+	distances, search := graph.shortestPaths(source, []hmmCandidate{target, behind, ahead}, 5000)
+
+	want := []float64{
+		length(1, 2)/2 + length(2, 3) + length(3, 13) + length(13, 12)/2,
+		length(1, 2)/2 + length(2, 1) + length(1, 2)/4, // turning around at the next junction and back
+		length(1, 2) / 4,
+	}
+	for k := range want {
+		if !closeTo(distances[k], want[k]) {
+			t.Errorf("distance to target %d = %v, want %v", k, distances[k], want[k])
+		}
+	}
+
+	path, roads := graph.pathTo(search, source, target)
+	wantPath := []Coordinates{testNodes[2], testNodes[3], testNodes[13], target.position}
+	if len(path) != len(wantPath) {
+		t.Fatalf("path = %v, want %v", path, wantPath)
+	}
+	for i := range wantPath {
+		if !closeTo(path[i].Lat, wantPath[i].Lat) || !closeTo(path[i].Lon, wantPath[i].Lon) {
+			t.Errorf("path[%d] = %v, want %v", i, path[i], wantPath[i])
+		}
+	}
+	wantRoads := []struct {
+		name     string
+		wayID    int64
+		nodeIDs  []int64
+		distance float64
+	}{
+		{"Hauptstraße", 100, []int64{1, 2, 3}, length(1, 2)/2 + length(2, 3)},
+		{"Ostweg", 103, []int64{3, 13}, length(3, 13)},
+		{"Parallelweg", 101, []int64{13, 12}, length(13, 12) / 2},
+	}
+	if len(roads) != len(wantRoads) {
+		t.Fatalf("roads = %+v, want %d roads", roads, len(wantRoads))
+	}
+	for i, want := range wantRoads {
+		road := roads[i]
+		if road.Name != want.name || road.WayID != want.wayID || !closeTo(road.Distance, want.distance) || len(road.NodeIDs) != len(want.nodeIDs) {
+			t.Errorf("road %d = %+v, want %+v", i, road, want)
+			continue
+		}
+		for j := range want.nodeIDs {
+			if road.NodeIDs[j] != want.nodeIDs[j] {
+				t.Errorf("road %d: node ids %v, want %v", i, road.NodeIDs, want.nodeIDs)
+				break
+			}
+		}
+	}
+	if roads[0].MaxSpeed != 50 || !closeTo(roads[0].Duration, roads[0].Distance/(50/3.6)) {
+		t.Errorf("Hauptstraße: max speed %v duration %v, want 50 km/h", roads[0].MaxSpeed, roads[0].Duration)
+	}
+
+	//beyond the search limit the target is unreachable
+	distances, _ = graph.shortestPaths(source, []hmmCandidate{target}, want[0]-1)
+	if !math.IsInf(distances[0], 1) {
+		t.Errorf("distance beyond the limit = %v, want +Inf", distances[0])
+	}
+}
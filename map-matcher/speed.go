@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	speedQueueName    = "vehicle.speed"
+	speedingQueueName = "speeding.detected"
+	speedingTolerance = 5.0 // km/h above the speed limit which are not reported as speeding
+)
+
+//VehicleSpeedMessageData is the average speed of a car on one leg of its matched route
+type VehicleSpeedMessageData struct {
+	MessageID    int     `json:"messageId"`
+	CarID        string  `json:"carId"`
+	TripID       string  `json:"tripId"`
+	VehicleType  string  `json:"vehicleType"`
+	Timestamp    string  `json:"timestamp"`
+	Start        string  `json:"start"`
+	End          string  `json:"end"`
+	Road         string  `json:"road"`
+	Distance     float64 `json:"distance"`
+	Duration     float64 `json:"duration"`
+	Speed        float64 `json:"speed"`              // km/h
+	MaxSpeed     float64 `json:"maxSpeed,omitempty"` // km/h, 0 if no limit is known
+	Interpolated bool    `json:"interpolated"`       // the distance is the one of a route through a gap, the speed a lower bound
+	Sender       string  `json:"sender"`
+	Topic        string  `json:"topic"`
+}
+
+//VehicleSpeedMessage published on vehicle.speed
+type VehicleSpeedMessage struct {
+	Data VehicleSpeedMessageData `json:"data"`
+}
+
+func (v VehicleSpeedMessage) toString() string {
+	return fmt.Sprintf("%+v\n", v)
+}
+
+//SpeedingMessageData reports a car driving faster than the limit of the road
+type SpeedingMessageData struct {
+	MessageID int     `json:"messageId"`
+	CarID     string  `json:"carId"`
+	TripID    string  `json:"tripId"`
+	Timestamp string  `json:"timestamp"`
+	Start     string  `json:"start"`
+	End       string  `json:"end"`
+	Road      string  `json:"road"`
+	Ref       string  `json:"ref,omitempty"`
+	WayID     int64   `json:"wayId,omitempty"`
+	MaxSpeed  float64 `json:"maxSpeed"` // km/h
+	Speed     float64 `json:"speed"`    // km/h
+	Duration  float64 `json:"duration"` // seconds
+	Sender    string  `json:"sender"`
+	Topic     string  `json:"topic"`
+}
+
+//SpeedingMessage published on speeding.detected
+type SpeedingMessage struct {
+	Data SpeedingMessageData `json:"data"`
+}
+
+func (s SpeedingMessage) toString() string {
+	return fmt.Sprintf("%+v\n", s)
+}
+
+//legSpeed returns the average speed in km/h on the leg and how long it took from the report timestamps
+func legSpeed(leg RouteLeg) (speed float64, duration float64, ok bool) {
+	start, err := time.Parse(time.RFC3339, leg.Start)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := time.Parse(time.RFC3339, leg.End)
+	if err != nil {
+		return 0, 0, false
+	}
+	duration = end.Sub(start).Seconds()
+	if duration <= 0 {
+		return 0, 0, false
+	}
+	return leg.Distance / duration * 3.6, duration, true
+}
+
+//legSpeedLimit returns the road of the leg with the highest known limit. An average speed above it
+//was certainly too fast on some part of the leg, whichever roads it consists of.
+func legSpeedLimit(leg RouteLeg) (RoadSection, bool) {
+	var limited RoadSection
+	for _, road := range leg.Roads {
+		if road.MaxSpeed > limited.MaxSpeed {
+			limited = road
+		}
+	}
+	return limited, limited.MaxSpeed > 0
+}
+
+//isSpeeding returns whether the speed exceeds the limit by more than the tolerance
+func isSpeeding(speed float64, maxSpeed float64) bool {
+	return speed > maxSpeed+speedingTolerance
+}
+
+//publishSpeeds publishes the speed on every leg of the matched route and a speeding event for legs driven too fast
+func publishSpeeds(msg MapMatcherMessage) {
+	for _, leg := range msg.Legs {
+		speed, duration, ok := legSpeed(leg)
+		if !ok {
+			continue
+		}
+		speedData := VehicleSpeedMessageData{
+			Sender:       "GoMicro-MapMatcher",
+			Topic:        speedQueueName,
+			MessageID:    msg.MessageID,
+			CarID:        msg.CarID,
+			TripID:       msg.TripID,
+			VehicleType:  msg.VehicleType,
+			Timestamp:    time.Now().Local().Format(time.RFC3339),
+			Start:        leg.Start,
+			End:          leg.End,
+			Distance:     leg.Distance,
+			Duration:     duration,
+			Speed:        speed,
+			Interpolated: leg.Interpolated,
+		}
+		road, limited := legSpeedLimit(leg)
+		if limited {
+			speedData.Road = roadName(road)
+			speedData.MaxSpeed = road.MaxSpeed
+		} else if len(leg.Roads) > 0 {
+			speedData.Road = roadName(leg.Roads[0])
+		}
+		publishSpeedMessage(VehicleSpeedMessage{Data: speedData})
+
+		if limited && isSpeeding(speed, road.MaxSpeed) {
+			publishSpeedingMessage(SpeedingMessage{
+				Data: SpeedingMessageData{
+					Sender:    "GoMicro-MapMatcher",
+					Topic:     speedingQueueName,
+					MessageID: msg.MessageID,
+					CarID:     msg.CarID,
+					TripID:    msg.TripID,
+					Timestamp: time.Now().Local().Format(time.RFC3339),
+					Start:     leg.Start,
+					End:       leg.End,
+					Road:      roadName(road),
+					Ref:       road.Ref,
+					WayID:     road.WayID,
+					MaxSpeed:  road.MaxSpeed,
+					Speed:     speed,
+					Duration:  duration,
+				},
+			})
+		}
+	}
+}
+
+//roadName returns the street name, or the reference number for unnamed roads like motorways
+func roadName(road RoadSection) string {
+	if road.Name != "" {
+		return road.Name
+	}
+	return road.Ref
+}
+
+func publishSpeedMessage(msg VehicleSpeedMessage) {
+	speedOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(speedQueueName, speedOutput)
+	fmt.Println("---published speed message---\n" + msg.toString())
+}
+
+func publishSpeedingMessage(msg SpeedingMessage) {
+	speedingOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(speedingQueueName, speedingOutput)
+	logMessage(msg.Data.MessageID, "speeding")
+	fmt.Printf("---published speeding of %.0f km/h where %.0f km/h are allowed---\n", msg.Data.Speed, msg.Data.MaxSpeed)
+}
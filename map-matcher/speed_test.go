@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLegSpeed(t *testing.T) {
+	at := func(seconds int) string {
+		return testStart.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339)
+	}
+	cases := []struct {
+		name     string
+		leg      RouteLeg
+		speed    float64
+		duration float64
+		ok       bool
+	}{
+		{"one kilometer in a minute", RouteLeg{Distance: 1000, Start: at(0), End: at(60)}, 60, 60, true},
+		{"standing", RouteLeg{Distance: 0, Start: at(0), End: at(30)}, 0, 30, true},
+		{"zero duration", RouteLeg{Distance: 50, Start: at(10), End: at(10)}, 0, 0, false},
+		{"end before start", RouteLeg{Distance: 50, Start: at(10), End: at(0)}, 0, 0, false},
+		{"no timestamps", RouteLeg{Distance: 1000}, 0, 0, false},
+	}
+	for _, c := range cases {
+		speed, duration, ok := legSpeed(c.leg)
+		if !closeTo(speed, c.speed) || duration != c.duration || ok != c.ok {
+			t.Errorf("%s: legSpeed = %v, %v, %v, want %v, %v, %v", c.name, speed, duration, ok, c.speed, c.duration, c.ok)
+		}
+	}
+}
+
+func TestLegSpeedLimit(t *testing.T) {
+	cases := []struct {
+		name    string
+		roads   []RoadSection
+		road    string
+		limited bool
+	}{
+		{"no limit", []RoadSection{{Name: "Feldweg"}, {Name: "Waldweg"}}, "", false},
+		{"no roads", nil, "", false},
+		{"one limit", []RoadSection{{Name: "Feldweg"}, {Name: "Hauptstraße", MaxSpeed: 50}}, "Hauptstraße", true},
+		{"different limits", []RoadSection{{Name: "Spielstraße", MaxSpeed: 7}, {Name: "Allee", MaxSpeed: 60}, {Name: "Hauptstraße", MaxSpeed: 50}}, "Allee", true},
+	}
+	for _, c := range cases {
+		road, limited := legSpeedLimit(RouteLeg{Roads: c.roads})
+		if road.Name != c.road || limited != c.limited {
+			t.Errorf("%s: legSpeedLimit = %q, %v, want %q, %v", c.name, road.Name, limited, c.road, c.limited)
+		}
+	}
+}
+
+func TestIsSpeeding(t *testing.T) {
+	defer func(tolerance float64) { speedingTolerance = tolerance }(speedingTolerance)
+	speedingTolerance = 5
+
+	cases := []struct {
+		speed    float64
+		maxSpeed float64
+		speeding bool
+	}{
+		{50, 50, false},
+		{55, 50, false},
+		{55.1, 50, true},
+		{90, 50, true},
+		{12, 7, false},
+		{12.5, 7, true},
+	}
+	for _, c := range cases {
+		if speeding := isSpeeding(c.speed, c.maxSpeed); speeding != c.speeding {
+			t.Errorf("isSpeeding(%v, %v) = %v, want %v", c.speed, c.maxSpeed, speeding, c.speeding)
+		}
+	}
+}
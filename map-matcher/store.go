@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//carState is what map-matcher buffers for a single car between two matchings
+type carState struct {
+	Last     *SimulatorMessageData  // last point of the previous batch, starts the next one so no road part is skipped
+	Points   []SimulatorMessageData // points received since
+	Trip     *trip
+	Previous *SimulatorMessageData // last accepted point as reported, before smoothing
+	Kalman   kalmanFilter
+	Parking  *parking // set while the car stands, its reports are not matched
+	LastSeen time.Time
+}
+
+//carUpdate tells what to do after a point was pushed or a car was evicted
+type carUpdate struct {
+	CarID    string
+	Batches  [][]SimulatorMessageData // to be matched in this order
+	Ended    *tripEvent
+	Started  *tripEvent
+	Rejected *rejection
+	Parked   *parking
+}
+
+//endTrip closes the running trip and returns its unmatched points
+func (c *carState) endTrip(reason string) ([][]SimulatorMessageData, *tripEvent) {
+	var batches [][]SimulatorMessageData
+	if len(c.Points) > 0 {
+		batches = append(batches, c.batch())
+	}
+	ended := &tripEvent{Trip: *c.Trip, Reason: reason}
+	c.Last = nil
+	c.Points = nil
+	c.Trip = nil
+	return batches, ended
+}
+
+//batch returns the points of the next matching in driving order
+func (c *carState) batch() []SimulatorMessageData {
+	var points []SimulatorMessageData
+	if c.Last != nil {
+		points = append(points, *c.Last)
+	}
+	return append(points, c.Points...)
+}
+
+//carStore is a concurrency safe store of the per car state which evicts cars that stopped reporting.
+//With a persistence every change is written behind and the states are restored on creation. Every update
+//is handed to handle before the store is unlocked, so the updates of a car arrive in the order they were made.
+type carStore struct {
+	mutex     sync.Mutex
+	cars      map[string]*carState
+	batchSize int
+	ttl       time.Duration
+	writer    *writeBehind
+	handle    func(carUpdate)
+}
+
+func newCarStore(batchSize int, ttl time.Duration, persistence carStatePersistence, handle func(carUpdate)) (*carStore, error) {
+	s := &carStore{
+		cars:      make(map[string]*carState),
+		batchSize: batchSize,
+		ttl:       ttl,
+		handle:    handle,
+	}
+	if persistence != nil {
+		cars, err := persistence.load()
+		if err != nil {
+			return nil, err
+		}
+		s.cars = cars
+		s.writer = newWriteBehind(persistence)
+	}
+	return s, nil
+}
+
+//persist queues the state of the car for writing, it is encoded right away as the store goes on changing it
+func (s *carStore) persist(carID string, state *carState) {
+	if s.writer != nil {
+		s.writer.queue(carID, state)
+	}
+}
+
+//push filters the point, assigns it to a trip and buffers it unless the car stands. The update holds the batch
+//to match once the car has enough points, the trips that ended or started with this point, a parking that
+//ended or why the point was rejected.
+func (s *carStore) push(point SimulatorMessageData, event string, now time.Time) carUpdate {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	update := s.apply(point, event, now)
+	if s.handle != nil {
+		s.handle(update)
+	}
+	return update
+}
+
+//apply changes the state of the car by the point, the store must be locked
+func (s *carStore) apply(point SimulatorMessageData, event string, now time.Time) carUpdate {
+	update := carUpdate{CarID: point.CarID}
+	state, ok := s.cars[point.CarID]
+	if !ok {
+		state = &carState{}
+		s.cars[point.CarID] = state
+	}
+	state.LastSeen = now
+
+	if reason := rejectReason(state.Previous, point); reason != "" {
+		update.Rejected = &rejection{Point: point, Reason: reason}
+		if event == "trip_end" && state.Trip != nil {
+			update.Parked = state.unpark()
+			update.Batches, update.Ended = state.endTrip(event)
+		}
+		s.persist(point.CarID, state)
+		return update
+	}
+	previous := point
+	state.Previous = &previous
+	point = state.Kalman.smooth(point)
+
+	if state.Trip != nil {
+		previous := state.Trip.End
+		if state.Parking != nil {
+			previous = state.Parking.End
+		}
+		reason := tripEndReason(previous, point, event)
+		if reason == "" && state.Parking != nil && state.Parking.dwell() > tripMaxGap && !state.stationary(point) {
+			//the car stood longer than a trip may pause and drives off now
+			reason = "parked"
+		}
+		if reason != "" {
+			update.Parked = state.unpark()
+			update.Batches, update.Ended = state.endTrip(reason)
+		}
+	}
+	if state.Trip == nil {
+		state.Trip = newTrip(point)
+		reason := "first_point"
+		if update.Ended != nil {
+			reason = update.Ended.Reason
+		} else if event == "trip_start" {
+			reason = event
+		}
+		update.Started = &tripEvent{Trip: *state.Trip, Reason: reason}
+	}
+	point.TripID = state.Trip.ID
+	if update.Started == nil && event != "trip_end" && state.stationary(point) {
+		//the car did not move, there is nothing to match
+		state.park(point)
+		s.persist(point.CarID, state)
+		return update
+	}
+	if parked := state.unpark(); parked != nil {
+		update.Parked = parked
+	}
+	state.Trip.add(point)
+	state.Points = append(state.Points, point)
+
+	if event == "trip_end" {
+		batches, ended := state.endTrip(event)
+		update.Batches = append(update.Batches, batches...)
+		update.Ended = ended
+	} else if points := state.batch(); len(points) >= s.batchSize {
+		state.Last = &points[len(points)-1]
+		state.Points = nil
+		update.Batches = append(update.Batches, points)
+	}
+	s.persist(point.CarID, state)
+	return update
+}
+
+//evict removes all cars idle for longer than the ttl, their trips end and their unmatched points are returned
+func (s *carStore) evict(now time.Time) []carUpdate {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var updates []carUpdate
+	for carID, state := range s.cars {
+		if now.Sub(state.LastSeen) <= s.ttl {
+			continue
+		}
+		if state.Trip != nil {
+			update := carUpdate{CarID: carID}
+			update.Parked = state.unpark()
+			update.Batches, update.Ended = state.endTrip("idle")
+			if s.handle != nil {
+				s.handle(update)
+			}
+			updates = append(updates, update)
+		}
+		delete(s.cars, carID)
+		s.persist(carID, nil)
+	}
+	return updates
+}
+
+//runEviction evicts idle cars periodically
+func (s *carStore) runEviction() {
+	interval := s.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for now := range time.Tick(interval) {
+		for _, update := range s.evict(now) {
+			fmt.Printf("--- Flushed idle car %s ---\n", update.CarID)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2026, 5, 4, 8, 0, 0, 0, time.UTC)
+
+//drive returns n reports of a car heading north at about 10 m/s, one every 10 seconds
+func drive(carID string, n int) []SimulatorMessageData {
+	points := make([]SimulatorMessageData, 0, n)
+	for i := 0; i < n; i++ {
+		points = append(points, SimulatorMessageData{
+			MessageID: i + 1,
+			CarID:     carID,
+			Timestamp: testStart.Add(time.Duration(i) * 10 * time.Second).Format(time.RFC3339),
+			Accuracy:  5,
+			Lat:       52.5 + float64(i)*0.0009,
+			Lon:       13.4,
+		})
+	}
+	return points
+}
+
+func messageIDs(points []SimulatorMessageData) []int {
+	ids := make([]int, 0, len(points))
+	for _, point := range points {
+		ids = append(ids, point.MessageID)
+	}
+	return ids
+}
+
+func TestPushConcurrentCars(t *testing.T) {
+	store, err := newCarStore(3, time.Minute, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const cars, reports = 8, 40
+	var wg sync.WaitGroup
+	updates := make([][]carUpdate, cars)
+	for c := 0; c < cars; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for _, point := range drive(fmt.Sprintf("car-%d", c), reports) {
+				updates[c] = append(updates[c], store.push(point, "", testStart))
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	for c, carUpdates := range updates {
+		started, matched := 0, map[int]bool{}
+		for _, update := range carUpdates {
+			if update.Rejected != nil {
+				t.Errorf("car-%d: point %d rejected: %s", c, update.Rejected.Point.MessageID, update.Rejected.Reason)
+			}
+			if update.Ended != nil {
+				t.Errorf("car-%d: trip ended: %s", c, update.Ended.Reason)
+			}
+			if update.Started != nil {
+				started++
+			}
+			for _, batch := range update.Batches {
+				for _, point := range batch {
+					if point.CarID != fmt.Sprintf("car-%d", c) {
+						t.Fatalf("car-%d: batch holds a point of %s", c, point.CarID)
+					}
+					matched[point.MessageID] = true
+				}
+			}
+		}
+		if started != 1 {
+			t.Errorf("car-%d: %d trips started, want 1", c, started)
+		}
+		//batches of three overlap by one point, so 1 to 39 were batched and 40 is still buffered
+		if len(matched) != reports-1 {
+			t.Errorf("car-%d: %d points batched, want %d", c, len(matched), reports-1)
+		}
+	}
+	if len(store.cars) != cars {
+		t.Errorf("store holds %d cars, want %d", len(store.cars), cars)
+	}
+}
+
+func TestEvictFlushesIdleCar(t *testing.T) {
+	ttl := time.Minute
+	store, err := newCarStore(3, ttl, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := drive("car-1", 4)
+	var batches [][]SimulatorMessageData
+	for _, point := range points {
+		batches = append(batches, store.push(point, "", testStart).Batches...)
+	}
+	if len(batches) != 1 || fmt.Sprint(messageIDs(batches[0])) != "[1 2 3]" {
+		t.Fatalf("batches before eviction = %v, want one of points 1 to 3", batches)
+	}
+
+	if updates := store.evict(testStart.Add(ttl)); len(updates) != 0 {
+		t.Fatalf("car evicted before its ttl passed: %+v", updates)
+	}
+	updates := store.evict(testStart.Add(ttl + time.Second))
+	if len(updates) != 1 {
+		t.Fatalf("%d updates after the ttl, want 1", len(updates))
+	}
+	update := updates[0]
+	if update.Ended == nil || update.Ended.Reason != "idle" {
+		t.Errorf("ended = %+v, want the trip ended as idle", update.Ended)
+	}
+	//the last point of the previous batch starts the leftover one
+	if len(update.Batches) != 1 || fmt.Sprint(messageIDs(update.Batches[0])) != "[3 4]" {
+		t.Errorf("leftover batches = %v, want points 3 and 4", update.Batches)
+	}
+	if _, ok := store.cars["car-1"]; ok {
+		t.Error("evicted car is still in the store")
+	}
+}
+
+func TestPersistedStatesAreRestored(t *testing.T) {
+	persistence, err := newBoltPersistence(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persistence.db.Close()
+	store, err := newCarStore(3, time.Minute, persistence, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, point := range drive("car-1", 4) {
+		store.push(point, "", testStart)
+	}
+	for _, point := range drive("car-2", 1) {
+		store.push(point, "", testStart)
+	}
+	store.evict(testStart.Add(2 * time.Minute))
+	store.push(drive("car-3", 1)[0], "", testStart.Add(2*time.Minute))
+	store.writer.flush()
+
+	restored, err := persistence.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || restored["car-3"] == nil {
+		t.Fatalf("restored cars = %v, want only car-3 after the others were evicted", restored)
+	}
+	if restored["car-3"].Trip == nil || len(restored["car-3"].Points) != 1 {
+		t.Errorf("car-3 restored as %+v, want its trip and buffered point", restored["car-3"])
+	}
+}
+
+func TestInterleavedPushesAreQueuedInOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var processing sync.WaitGroup
+	processed := map[string][]carUpdate{}
+	workers := newCarWorkers(func(update carUpdate) {
+		defer processing.Done()
+		mutex.Lock()
+		processed[update.CarID] = append(processed[update.CarID], update)
+		mutex.Unlock()
+	})
+	store, err := newCarStore(3, time.Minute, nil, func(update carUpdate) {
+		processing.Add(1)
+		//lets another push run in between if the store did not hold the car while handing over the update
+		runtime.Gosched()
+		workers.enqueue(update)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//each car's reports are spread over three goroutines, so they reach the store interleaved and partly late
+	const cars, reports, senders = 4, 60, 3
+	var wg sync.WaitGroup
+	for c := 0; c < cars; c++ {
+		points := drive(fmt.Sprintf("car-%d", c), reports)
+		for s := 0; s < senders; s++ {
+			wg.Add(1)
+			go func(s int) {
+				defer wg.Done()
+				for i := s; i < len(points); i += senders {
+					store.push(points[i], "", testStart)
+				}
+			}(s)
+		}
+	}
+	wg.Wait()
+	store.evict(testStart.Add(2 * time.Minute))
+	processing.Wait()
+
+	for c := 0; c < cars; c++ {
+		carID := fmt.Sprintf("car-%d", c)
+		var last *SimulatorMessageData
+		started, ended := 0, 0
+		for _, update := range processed[carID] {
+			if update.Started != nil {
+				started++
+			}
+			if update.Ended != nil {
+				ended++
+			}
+			for _, batch := range update.Batches {
+				//a batch starts with the last point of the one before, unless the car was just flushed
+				if last != nil && batch[0].MessageID != last.MessageID {
+					t.Fatalf("%s: batch %v does not continue at point %d", carID, messageIDs(batch), last.MessageID)
+				}
+				for i := 1; i < len(batch); i++ {
+					if batch[i].MessageID <= batch[i-1].MessageID {
+						t.Fatalf("%s: batch %v is out of order", carID, messageIDs(batch))
+					}
+				}
+				last = &batch[len(batch)-1]
+			}
+		}
+		if started != 1 || ended != 1 {
+			t.Errorf("%s: %d trips started and %d ended, want 1 each", carID, started, ended)
+		}
+		if last == nil {
+			t.Errorf("%s: no batch was processed", carID)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	tripStartedQueueName = "trip.started"
+	tripEndedQueueName   = "trip.ended"
+	tripMaxGap           = 10 * time.Minute // a longer pause between two points starts a new trip
+	tripMaxJump          = 10000.0          // meters, a longer jump between two points starts a new trip
+)
+
+//trip is the running summary of the trip a car is on
+type trip struct {
+	ID       string
+	Start    SimulatorMessageData
+	End      SimulatorMessageData
+	Points   int
+	Distance float64 // meters along the reported points
+}
+
+func newTrip(point SimulatorMessageData) *trip {
+	return &trip{
+		ID:    point.CarID + "-" + strconv.Itoa(point.MessageID),
+		Start: point,
+		End:   point,
+	}
+}
+
+func (t *trip) add(point SimulatorMessageData) {
+	if t.Points > 0 {
+		t.Distance += Distance(t.End.Lat, t.End.Lon, point.Lat, point.Lon)
+	}
+	t.End = point
+	t.Points++
+}
+
+//tripEndReason returns why point does not belong to the trip of previous anymore, empty if it does
+func tripEndReason(previous SimulatorMessageData, point SimulatorMessageData, event string) string {
+	if event == "trip_start" {
+		return "trip_start"
+	}
+	if from, to, err := pointTimes(previous, point); err == nil && to.Sub(from) > tripMaxGap {
+		return "gap"
+	}
+	if Distance(previous.Lat, previous.Lon, point.Lat, point.Lon) > tripMaxJump {
+		return "jump"
+	}
+	return ""
+}
+
+//tripEvent is a trip which started or ended together with the reason
+type tripEvent struct {
+	Trip   trip
+	Reason string
+}
+
+//TripMessageData summarizes a trip when it starts or ends
+type TripMessageData struct {
+	MessageID      int         `json:"messageId"`
+	CarID          string      `json:"carId"`
+	TripID         string      `json:"tripId"`
+	Timestamp      string      `json:"timestamp"`
+	StartTimestamp string      `json:"startTimestamp"`
+	EndTimestamp   string      `json:"endTimestamp"`
+	Start          Coordinates `json:"start"`
+	End            Coordinates `json:"end"`
+	Points         int         `json:"points"`
+	Distance       float64     `json:"distance"`
+	Duration       float64     `json:"duration"`
+	Reason         string      `json:"reason"`
+	Sender         string      `json:"sender"`
+	Topic          string      `json:"topic"`
+}
+
+//TripMessage published on trip.started and trip.ended
+type TripMessage struct {
+	Data TripMessageData `json:"data"`
+}
+
+func (t TripMessage) toString() string {
+	return fmt.Sprintf("%+v\n", t)
+}
+
+func publishTripMessage(queueName string, event tripEvent) {
+	msgData := TripMessageData{
+		Sender:         "GoMicro-MapMatcher",
+		Topic:          queueName,
+		MessageID:      event.Trip.End.MessageID,
+		CarID:          event.Trip.Start.CarID,
+		TripID:         event.Trip.ID,
+		Timestamp:      time.Now().Local().Format(time.RFC3339),
+		StartTimestamp: event.Trip.Start.Timestamp,
+		EndTimestamp:   event.Trip.End.Timestamp,
+		Start:          Coordinates{Lat: event.Trip.Start.Lat, Lon: event.Trip.Start.Lon},
+		End:            Coordinates{Lat: event.Trip.End.Lat, Lon: event.Trip.End.Lon},
+		Points:         event.Trip.Points,
+		Distance:       event.Trip.Distance,
+		Reason:         event.Reason,
+	}
+	if from, to, err := pointTimes(event.Trip.Start, event.Trip.End); err == nil {
+		msgData.Duration = to.Sub(from).Seconds()
+	}
+
+	msg := TripMessage{Data: msgData}
+	tripOutput, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalNatsConn.Publish(queueName, tripOutput)
+	logMessage(msgData.MessageID, "sent")
+	fmt.Println("---published trip message---\n" + msg.toString())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTripEndReason(t *testing.T) {
+	at := func(seconds int, north float64) SimulatorMessageData {
+		return SimulatorMessageData{
+			Timestamp: testStart.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339),
+			Lat:       52.5 + north/metersPerDegree,
+			Lon:       13.4,
+		}
+	}
+	untimed := func(point SimulatorMessageData) SimulatorMessageData {
+		point.Timestamp = ""
+		return point
+	}
+	cases := []struct {
+		name     string
+		from, to SimulatorMessageData
+		event    string
+		reason   string
+	}{
+		{"next report", at(0, 0), at(10, 100), "", ""},
+		{"pause as long as allowed", at(0, 0), at(600, 100), "", ""},
+		{"gap", at(0, 0), at(601, 100), "", "gap"},
+		{"jump", at(0, 0), at(10, 10001), "", "jump"},
+		{"jump without timestamps", untimed(at(0, 0)), untimed(at(10, 10001)), "", "jump"},
+		{"no timestamps", untimed(at(0, 0)), untimed(at(10, 100)), "", ""},
+		{"ignition on", at(0, 0), at(10, 100), "trip_start", "trip_start"},
+		{"ignition off ends after the point", at(0, 0), at(10, 100), "trip_end", ""},
+	}
+	for _, c := range cases {
+		if reason := tripEndReason(c.from, c.to, c.event); reason != c.reason {
+			t.Errorf("%s: tripEndReason = %q, want %q", c.name, reason, c.reason)
+		}
+	}
+}
+
+//tripIDs returns the trip ids of the points in the batches
+func tripIDs(batches [][]SimulatorMessageData) map[string]bool {
+	ids := make(map[string]bool)
+	for _, batch := range batches {
+		for _, point := range batch {
+			ids[point.TripID] = true
+		}
+	}
+	return ids
+}
+
+func TestEndedTripKeepsItsBatches(t *testing.T) {
+	cases := []struct {
+		name      string
+		gap       time.Duration // pause before the fifth report
+		event     string        // event sent with the fifth report
+		evict     bool          // the car is evicted instead of sending the fifth report
+		reason    string
+		ended     string // message ids of the batches of the ended trip
+		started   string // reason the new trip starts with
+		nextFirst int    // message id the new trip starts at
+	}{
+		{"gap", 15 * time.Minute, "", false, "gap", "[[3 4]]", "gap", 5},
+		{"ignition off", 0, "trip_end", false, "trip_end", "[[3 4 5]]", "first_point", 6},
+		{"idle", 0, "", true, "idle", "[[3 4]]", "first_point", 5},
+	}
+	for _, c := range cases {
+		store, err := newCarStore(3, time.Minute, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		route := drive("car-1", 9)
+		for i := 4; i < len(route); i++ {
+			timestamp, _ := time.Parse(time.RFC3339, route[i].Timestamp)
+			route[i].Timestamp = timestamp.Add(c.gap).Format(time.RFC3339)
+		}
+		tripID := store.push(route[0], "", testStart).Started.Trip.ID
+		for _, point := range route[1:4] {
+			store.push(point, "", testStart)
+		}
+
+		var update carUpdate
+		next := route[4:]
+		if c.evict {
+			update = store.evict(testStart.Add(2 * time.Minute))[0]
+		} else {
+			update = store.push(route[4], c.event, testStart)
+			next = route[5:]
+		}
+		if update.Ended == nil || update.Ended.Reason != c.reason || update.Ended.Trip.ID != tripID {
+			t.Fatalf("%s: ended = %+v, want trip %s ended as %s", c.name, update.Ended, tripID, c.reason)
+		}
+		if batches := fmt.Sprint(batchIDs(update.Batches)); batches != c.ended {
+			t.Errorf("%s: batches of the ended trip = %s, want %s", c.name, batches, c.ended)
+		}
+		if ids := tripIDs(update.Batches); len(ids) != 1 || !ids[tripID] {
+			t.Errorf("%s: batches of the ended trip belong to %v", c.name, ids)
+		}
+
+		//the new trip starts from scratch, its first batch does not reach back into the ended trip
+		started := update.Started
+		var batches [][]SimulatorMessageData
+		for _, point := range next {
+			update := store.push(point, "", testStart)
+			if update.Ended != nil {
+				t.Errorf("%s: new trip ended at point %d as %s", c.name, point.MessageID, update.Ended.Reason)
+			}
+			if update.Started != nil {
+				started = update.Started
+			}
+			batches = append(batches, update.Batches...)
+		}
+		if started == nil || started.Reason != c.started || started.Trip.Start.MessageID != c.nextFirst || started.Trip.ID == tripID {
+			t.Errorf("%s: started = %+v, want a new trip from point %d as %s", c.name, started, c.nextFirst, c.started)
+			continue
+		}
+		if len(batches) == 0 || batches[0][0].MessageID != c.nextFirst {
+			t.Errorf("%s: batches of the new trip = %v, want them to start at point %d", c.name, batchIDs(batches), c.nextFirst)
+		}
+		if ids := tripIDs(batches); len(ids) != 1 || !ids[started.Trip.ID] {
+			t.Errorf("%s: batches of the new trip belong to %v", c.name, ids)
+		}
+	}
+}
+
+func batchIDs(batches [][]SimulatorMessageData) [][]int {
+	ids := make([][]int, 0, len(batches))
+	for _, batch := range batches {
+		ids = append(ids, messageIDs(batch))
+	}
+	return ids
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//valhallaMatcher matches with the trace_attributes service of a valhalla server
+type valhallaMatcher struct {
+	uri        string
+	costing    string
+	httpClient *http.Client
+}
+
+type valhallaRequest struct {
+	Shape        []valhallaPoint      `json:"shape"`
+	Costing      string               `json:"costing"`
+	ShapeMatch   string               `json:"shape_match"`
+	TraceOptions valhallaTraceOptions `json:"trace_options"`
+}
+
+type valhallaPoint struct {
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+	Time int64   `json:"time,omitempty"`
+}
+
+type valhallaTraceOptions struct {
+	SearchRadius float64 `json:"search_radius"`
+}
+
+type valhallaResponse struct {
+	Edges           []valhallaEdge         `json:"edges"`
+	MatchedPoints   []valhallaMatchedPoint `json:"matched_points"`
+	Shape           string                 `json:"shape"`
+	ConfidenceScore *float64               `json:"confidence_score"`
+	ErrorCode       int                    `json:"error_code"`
+	Error           string                 `json:"error"`
+}
+
+type valhallaEdge struct {
+	Length     float64  `json:"length"` // kilometers
+	Speed      float64  `json:"speed"`  // km/h
+	Names      []string `json:"names"`
+	WayID      int64    `json:"way_id"`
+	RoadClass  string   `json:"road_class"`
+	SpeedLimit float64  `json:"speed_limit"` // km/h, 0 if unknown and 255 if unlimited
+}
+
+type valhallaMatchedPoint struct {
+	Lat               float64 `json:"lat"`
+	Lon               float64 `json:"lon"`
+	Type              string  `json:"type"`
+	EdgeIndex         int     `json:"edge_index"`
+	DistanceAlongEdge float64 `json:"distance_along_edge"` // fraction of the edge length
+}
+
+func newValhallaMatcher(uri string, costing string) *valhallaMatcher {
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+	return &valhallaMatcher{
+		uri:        strings.TrimRight(uri, "/"),
+		costing:    costing,
+		httpClient: &http.Client{Timeout: osrmOptions().Timeout},
+	}
+}
+
+func (m *valhallaMatcher) Match(points []SimulatorMessageData) (MatchedRoute, error) {
+	var route MatchedRoute
+	request := valhallaRequest{
+		Costing:      m.costing,
+		ShapeMatch:   "map_snap",
+		TraceOptions: valhallaTraceOptions{SearchRadius: matchRadiuses[0]},
+	}
+	for _, point := range points {
+		shapePoint := valhallaPoint{Lat: point.Lat, Lon: point.Lon}
+		if timestamp, err := time.Parse(time.RFC3339, point.Timestamp); err == nil {
+			shapePoint.Time = timestamp.Unix()
+		}
+		request.Shape = append(request.Shape, shapePoint)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return route, err
+	}
+
+	fmt.Println("---sending Data to valhalla---")
+	resp, err := m.httpClient.Post(m.uri+"/trace_attributes", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return route, err
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return route, err
+	}
+	var valhallaRes valhallaResponse
+	if err := json.Unmarshal(body, &valhallaRes); err != nil {
+		return route, fmt.Errorf("valhalla responded with status %d: %v", resp.StatusCode, err)
+	}
+	if valhallaRes.ErrorCode != 0 {
+		return route, MatchError{Code: strconv.Itoa(valhallaRes.ErrorCode), Reason: valhallaRes.Error}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return route, fmt.Errorf("valhalla responded with status %d", resp.StatusCode)
+	}
+
+	fmt.Printf("--- Valhalla output----\n")
+	fmt.Printf("%+v\n", valhallaRes)
+
+	route.Route = decodePolyline(valhallaRes.Shape, 1e6)
+	//older valhalla versions do not score the match, it is trusted then like the other backends do
+	route.Confidence = 1
+	if valhallaRes.ConfidenceScore != nil {
+		route.Confidence = *valhallaRes.ConfidenceScore
+	}
+	for _, edge := range valhallaRes.Edges {
+		route.Distance += edge.Length * 1000
+		route.Duration += edgeDuration(edge, 1)
+	}
+
+	//legs run between consecutive matched points, which may lie somewhere along an edge
+	previous := -1
+	for i, matchedPoint := range valhallaRes.MatchedPoints {
+		if matchedPoint.Type == "unmatched" {
+			if i < len(points) {
+				route.Unmatched = append(route.Unmatched, points[i])
+			}
+			continue
+		}
+		if previous >= 0 {
+			leg := valhallaLeg(valhallaRes.Edges, valhallaRes.MatchedPoints[previous], matchedPoint)
+			if i < len(points) {
+				leg.Start, leg.End = points[previous].Timestamp, points[i].Timestamp
+			}
+			route.Legs = append(route.Legs, leg)
+		}
+		previous = i
+	}
+	return route, nil
+}
+
+//valhallaLeg sums the edge parts between two matched points
+func valhallaLeg(edges []valhallaEdge, from valhallaMatchedPoint, to valhallaMatchedPoint) RouteLeg {
+	var leg RouteLeg
+	if from.EdgeIndex < 0 || to.EdgeIndex >= len(edges) || from.EdgeIndex > to.EdgeIndex {
+		return leg
+	}
+	for i := from.EdgeIndex; i <= to.EdgeIndex; i++ {
+		fraction := 1.0
+		if i == from.EdgeIndex {
+			fraction -= from.DistanceAlongEdge
+		}
+		if i == to.EdgeIndex {
+			fraction -= 1 - to.DistanceAlongEdge
+		}
+		road := RoadSection{
+			WayID:     edges[i].WayID,
+			RoadClass: edges[i].RoadClass,
+			Distance:  edges[i].Length * 1000 * fraction,
+			Duration:  edgeDuration(edges[i], fraction),
+		}
+		if edges[i].SpeedLimit > 0 && edges[i].SpeedLimit < 255 {
+			road.MaxSpeed = edges[i].SpeedLimit
+		}
+		if len(edges[i].Names) > 0 {
+			road.Name = edges[i].Names[0]
+		}
+		leg.Roads = appendRoad(leg.Roads, road)
+		leg.Distance += road.Distance
+		leg.Duration += road.Duration
+	}
+	return leg
+}
+
+//edgeDuration returns the seconds needed for the fraction of the edge at its speed
+func edgeDuration(edge valhallaEdge, fraction float64) float64 {
+	if edge.Speed <= 0 {
+		return 0
+	}
+	return edge.Length * fraction / edge.Speed * 3600
+}
+
+//decodePolyline decodes an encoded polyline, valhalla uses a precision of 1e6
+func decodePolyline(encoded string, precision float64) []Coordinates {
+	var coordinates []Coordinates
+	var lat, lon int64
+	index := 0
+	for index < len(encoded) {
+		for _, value := range []*int64{&lat, &lon} {
+			var result int64
+			var shift uint
+			for index < len(encoded) {
+				b := int64(encoded[index]) - 63
+				index++
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				*value += ^(result >> 1)
+			} else {
+				*value += result >> 1
+			}
+		}
+		coordinates = append(coordinates, Coordinates{
+			Lat: float64(lat) / precision,
+			Lon: float64(lon) / precision,
+		})
+	}
+	return coordinates
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	cases := []struct {
+		name      string
+		encoded   string
+		precision float64
+		want      []Coordinates
+	}{
+		{"google example", "_p~iF~ps|U_ulLnnqC_mqNvxq`@", 1e5, []Coordinates{
+			{Lat: 38.5, Lon: -120.2}, {Lat: 40.7, Lon: -120.95}, {Lat: 43.252, Lon: -126.453},
+		}},
+		{"valhalla shape", "yikdcBwbepXcdWkcPvaKqk{@", 1e6, []Coordinates{
+			{Lat: 52.517037, Lon: 13.38886}, {Lat: 52.529407, Lon: 13.397634}, {Lat: 52.523219, Lon: 13.428555},
+		}},
+		{"southern and eastern hemisphere", "~omq_Aakml_H_gL`{\\", 1e6, []Coordinates{
+			{Lat: -33.856784, Lon: 151.215297}, {Lat: -33.85, Lon: 151.2},
+		}},
+		{"empty", "", 1e6, nil},
+	}
+	for _, c := range cases {
+		got := decodePolyline(c.encoded, c.precision)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range c.want {
+			if math.Abs(got[i].Lat-c.want[i].Lat) > 1e-9 || math.Abs(got[i].Lon-c.want[i].Lon) > 1e-9 {
+				t.Errorf("%s: point %d = %v, want %v", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+const valhallaTrace = `{
+	"shape":"yikdcBwbepXcdWkcPvaKqk{@",
+	"confidence_score":0.75,
+	"edges":[
+		{"length":0.5,"speed":50,"names":["Hauptstraße"],"way_id":10,"road_class":"primary","speed_limit":50},
+		{"length":0.3,"speed":30,"names":["Nebenweg","B 1"],"way_id":11,"road_class":"residential","speed_limit":255}],
+	"matched_points":[
+		{"lat":52.517037,"lon":13.38886,"type":"matched","edge_index":0,"distance_along_edge":0.2},
+		{"lat":52.52,"lon":13.39,"type":"unmatched","edge_index":0},
+		{"lat":52.529407,"lon":13.397634,"type":"matched","edge_index":1,"distance_along_edge":0.5},
+		{"lat":52.523219,"lon":13.428555,"type":"matched","edge_index":1,"distance_along_edge":1}]}`
+
+func TestValhallaMatchRoundTrip(t *testing.T) {
+	at := func(minute int, lat float64, lon float64) SimulatorMessageData {
+		return SimulatorMessageData{Timestamp: testStart.Add(time.Duration(minute) * time.Minute).Format(time.RFC3339), Lat: lat, Lon: lon}
+	}
+	points := []SimulatorMessageData{at(0, 52.517, 13.3888), at(1, 52.52, 13.39), at(2, 52.5294, 13.3976), at(3, 52.5232, 13.4285)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/trace_attributes" {
+			t.Errorf("request %s %s, want POST /trace_attributes", r.Method, r.URL.Path)
+		}
+		var request valhallaRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		if request.Costing != "truck" || request.ShapeMatch != "map_snap" || request.TraceOptions.SearchRadius != matchRadiuses[0] {
+			t.Errorf("request options = %+v", request)
+		}
+		if len(request.Shape) != len(points) {
+			t.Errorf("shape has %d points, want %d", len(request.Shape), len(points))
+		}
+		for i, shapePoint := range request.Shape {
+			if shapePoint.Lat != points[i].Lat || shapePoint.Lon != points[i].Lon || shapePoint.Time != testStart.Unix()+int64(i*60) {
+				t.Errorf("shape point %d = %+v", i, shapePoint)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(valhallaTrace))
+	}))
+	defer server.Close()
+	matcher := newValhallaMatcher(server.URL, "truck")
+
+	route, err := matcher.Match(points)
+	if err != nil {
+		t.Fatalf("match failed: %v", err)
+	}
+
+	if len(route.Route) != 3 || route.Route[1] != (Coordinates{Lat: 52.529407, Lon: 13.397634}) {
+		t.Errorf("route = %v, want the decoded shape", route.Route)
+	}
+	if route.Confidence != 0.75 {
+		t.Errorf("confidence = %v, want 0.75", route.Confidence)
+	}
+	if !closeTo(route.Distance, 800) || !closeTo(route.Duration, 72) {
+		t.Errorf("distance %v duration %v, want 800 and 72", route.Distance, route.Duration)
+	}
+	if len(route.Unmatched) != 1 || route.Unmatched[0] != points[1] {
+		t.Errorf("unmatched = %v, want the second point", route.Unmatched)
+	}
+
+	//the first leg skips the unmatched point and runs from 20% along the first edge to the middle of the second
+	want := []RouteLeg{
+		{Distance: 550, Duration: 46.8, Start: points[0].Timestamp, End: points[2].Timestamp, Roads: []RoadSection{
+			{Name: "Hauptstraße", WayID: 10, RoadClass: "primary", MaxSpeed: 50, Distance: 400, Duration: 28.8},
+			{Name: "Nebenweg", WayID: 11, RoadClass: "residential", Distance: 150, Duration: 18},
+		}},
+		{Distance: 150, Duration: 18, Start: points[2].Timestamp, End: points[3].Timestamp, Roads: []RoadSection{
+			{Name: "Nebenweg", WayID: 11, RoadClass: "residential", Distance: 150, Duration: 18},
+		}},
+	}
+	if len(route.Legs) != len(want) {
+		t.Fatalf("got %d legs, want %d", len(route.Legs), len(want))
+	}
+	for i, leg := range route.Legs {
+		if !closeTo(leg.Distance, want[i].Distance) || !closeTo(leg.Duration, want[i].Duration) || leg.Start != want[i].Start || leg.End != want[i].End {
+			t.Errorf("leg %d = %+v, want %+v", i, leg, want[i])
+		}
+		if len(leg.Roads) != len(want[i].Roads) {
+			t.Errorf("leg %d: roads = %+v, want %+v", i, leg.Roads, want[i].Roads)
+			continue
+		}
+		for j, road := range leg.Roads {
+			wantRoad := want[i].Roads[j]
+			if road.Name != wantRoad.Name || road.WayID != wantRoad.WayID || road.RoadClass != wantRoad.RoadClass || road.MaxSpeed != wantRoad.MaxSpeed ||
+				!closeTo(road.Distance, wantRoad.Distance) || !closeTo(road.Duration, wantRoad.Duration) {
+				t.Errorf("leg %d road %d = %+v, want %+v", i, j, road, wantRoad)
+			}
+		}
+	}
+}
+
+func TestValhallaErrorIsMatchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error_code":442,"error":"No suitable edges near location","status_code":400}`))
+	}))
+	defer server.Close()
+
+	_, err := newValhallaMatcher(server.URL, "auto").Match([]SimulatorMessageData{{Lat: 52.5, Lon: 13.4}, {Lat: 52.51, Lon: 13.4}})
+	matchErr, ok := err.(MatchError)
+	if !ok {
+		t.Fatalf("err = %v, want a MatchError", err)
+	}
+	if matchErr.Code != "442" || matchErr.Reason != "No suitable edges near location" {
+		t.Errorf("err = %+v", matchErr)
+	}
+}
+
+func closeTo(got float64, want float64) bool {
+	return math.Abs(got-want) < 1e-6
+}
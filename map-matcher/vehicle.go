@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	defaultVehicleType  = "car"
+	vehicleRegistryPath = os.Getenv("VEHICLE_REGISTRY") // json file mapping car ids to vehicle types
+	vehicleRegistry     = make(map[string]string)
+	//vehicleProfiles are the osrm profile and valhalla costing used for each vehicle type
+	vehicleProfiles = map[string]vehicleProfile{
+		"car":        {osrm: "car", valhalla: "auto"},
+		"truck":      {osrm: "truck", valhalla: "truck"},
+		"cargo_bike": {osrm: "bike", valhalla: "bicycle"},
+	}
+	matchersMutex sync.Mutex
+	matchers      = make(map[string]*matcherEntry) // vehicle type to its matcher, created on first use
+)
+
+//matcherEntry builds the matcher of a vehicle type once, matching of the other types goes on meanwhile
+type matcherEntry struct {
+	once    sync.Once
+	matcher Matcher
+	err     error
+}
+
+type vehicleProfile struct {
+	osrm     string
+	valhalla string
+}
+
+//loadVehicleRegistry reads the vehicle types of the fleet from a json object of car id to vehicle type
+func loadVehicleRegistry(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	registry := make(map[string]string)
+	if err := json.Unmarshal(content, &registry); err != nil {
+		return err
+	}
+	for carID, vehicleType := range registry {
+		if _, ok := vehicleProfiles[vehicleType]; !ok {
+			return fmt.Errorf("car %s has unknown vehicle type %q", carID, vehicleType)
+		}
+	}
+	vehicleRegistry = registry
+	return nil
+}
+
+//vehicleTypeOf returns the vehicle type reported with the point, else the registered one
+func vehicleTypeOf(point SimulatorMessageData) string {
+	if point.VehicleType != "" {
+		return point.VehicleType
+	}
+	if vehicleType, ok := vehicleRegistry[point.CarID]; ok {
+		return vehicleType
+	}
+	return defaultVehicleType
+}
+
+//configuredVehicleTypes are the default vehicle type and the types of the registered fleet
+func configuredVehicleTypes() []string {
+	vehicleTypes := []string{defaultVehicleType}
+	for _, vehicleType := range vehicleRegistry {
+		known := false
+		for _, existing := range vehicleTypes {
+			known = known || existing == vehicleType
+		}
+		if !known {
+			vehicleTypes = append(vehicleTypes, vehicleType)
+		}
+	}
+	return vehicleTypes
+}
+
+//matcherFor returns the matcher of the vehicle type. The configured types are created at startup, others
+//on first use without holding up the matching of the other types.
+func matcherFor(vehicleType string) (Matcher, error) {
+	if _, ok := vehicleProfiles[vehicleType]; !ok {
+		return nil, MatchError{Code: "InvalidVehicleType", Reason: "unknown vehicle type " + vehicleType}
+	}
+	matchersMutex.Lock()
+	entry, ok := matchers[vehicleType]
+	if !ok {
+		entry = &matcherEntry{}
+		matchers[vehicleType] = entry
+	}
+	matchersMutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.matcher, entry.err = newMatcher(matcherBackend, vehicleType)
+	})
+	return entry.matcher, entry.err
+}
+
+//osrmURIFor returns the backends of the vehicle type from OSRM_URI_<TYPE>, e.g. OSRM_URI_TRUCK. An osrm
+//dataset is extracted for a single profile, so other vehicle types are never matched on the car dataset.
+func osrmURIFor(vehicleType string) (string, error) {
+	variable := "OSRM_URI_" + strings.ToUpper(vehicleType)
+	if uri := os.Getenv(variable); uri != "" {
+		return uri, nil
+	}
+	if vehicleType != defaultVehicleType {
+		return "", MatchError{Code: "NoBackend", Reason: "no " + variable + " set, " + vehicleType + " cannot be matched on the " + defaultVehicleType + " dataset"}
+	}
+	return osrmURI, nil
+}
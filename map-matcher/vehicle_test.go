@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestNoOSRMBackendForVehicleType(t *testing.T) {
+	t.Setenv("OSRM_URI_TRUCK", "")
+	if _, err := newMatcher("osrm", "truck"); err == nil {
+		t.Fatal("truck got a matcher without OSRM_URI_TRUCK")
+	} else if matchErr, ok := err.(MatchError); !ok || matchErr.Code != "NoBackend" {
+		t.Errorf("err = %v, want a NoBackend match error", err)
+	}
+
+	t.Setenv("OSRM_URI_TRUCK", "osrm-truck-server:5000")
+	if uri, err := osrmURIFor("truck"); err != nil || uri != "osrm-truck-server:5000" {
+		t.Errorf("osrmURIFor(truck) = %q, %v, want the truck backend", uri, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+//updateWorkers processes the updates of all cars
+var updateWorkers = newCarWorkers(processCarUpdate)
+
+//carWorkers process the updates of each car one after another in the order they were queued, different
+//cars are processed concurrently. A car's worker only runs while it has updates queued.
+type carWorkers struct {
+	mutex   sync.Mutex
+	queues  map[string][]carUpdate
+	process func(carUpdate)
+}
+
+func newCarWorkers(process func(carUpdate)) *carWorkers {
+	return &carWorkers{queues: make(map[string][]carUpdate), process: process}
+}
+
+//enqueue queues the update and starts the worker of the car unless it is running
+func (w *carWorkers) enqueue(update carUpdate) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	queue, running := w.queues[update.CarID]
+	w.queues[update.CarID] = append(queue, update)
+	if !running {
+		go w.run(update.CarID)
+	}
+}
+
+func (w *carWorkers) run(carID string) {
+	for {
+		w.mutex.Lock()
+		queue := w.queues[carID]
+		if len(queue) == 0 {
+			delete(w.queues, carID)
+			w.mutex.Unlock()
+			return
+		}
+		update := queue[0]
+		w.queues[carID] = queue[1:]
+		w.mutex.Unlock()
+
+		w.process(update)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCarWorkersKeepOrderPerCar(t *testing.T) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	processed := make(map[string][]int)
+	running := make(map[string]bool)
+	workers := newCarWorkers(func(update carUpdate) {
+		defer wg.Done()
+		mutex.Lock()
+		if running[update.CarID] {
+			t.Errorf("%s: two updates processed at once", update.CarID)
+		}
+		running[update.CarID] = true
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		running[update.CarID] = false
+		processed[update.CarID] = append(processed[update.CarID], update.Batches[0][0].MessageID)
+		mutex.Unlock()
+	})
+
+	const cars, updates = 4, 20
+	for i := 1; i <= updates; i++ {
+		for c := 0; c < cars; c++ {
+			wg.Add(1)
+			point := SimulatorMessageData{MessageID: i, CarID: fmt.Sprintf("car-%d", c)}
+			workers.enqueue(carUpdate{CarID: point.CarID, Batches: [][]SimulatorMessageData{{point}}})
+		}
+	}
+	wg.Wait()
+
+	for c := 0; c < cars; c++ {
+		carID := fmt.Sprintf("car-%d", c)
+		for i, messageID := range processed[carID] {
+			if messageID != i+1 {
+				t.Fatalf("%s: updates processed as %v, want in queued order", carID, processed[carID])
+			}
+		}
+		if len(processed[carID]) != updates {
+			t.Errorf("%s: %d updates processed, want %d", carID, len(processed[carID]), updates)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+-- Truck profile: the car profile with heavy goods vehicle access tags, dimensions and truck speeds
+
+local car = dofile('/opt/car.lua')
+
+function setup()
+  local profile = car.setup()
+
+  profile.properties.max_speed_for_map_matching = 90/3.6
+  profile.vehicle_height = 4.0
+  profile.vehicle_width = 2.55
+  profile.vehicle_length = 16.5
+  profile.vehicle_weight = 40000
+
+  -- hgv tags are more specific than the motor vehicle ones, so they are checked first
+  table.insert(profile.access_tags_hierarchy, 1, 'hgv')
+  table.insert(profile.restrictions, 1, 'hgv')
+  profile.access_tag_blacklist['delivery'] = nil
+
+  for highway, speed in pairs(profile.speeds.highway) do
+    profile.speeds.highway[highway] = math.min(speed, 80)
+  end
+
+  return profile
+end
+
+return {
+  setup = setup,
+  process_way = car.process_way,
+  process_node = car.process_node,
+  process_turn = car.process_turn
+}
@@ -0,0 +1,74 @@
+package main
+
+import "math"
+
+//Distance returns haversine distance in meters
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	// convert to radians
+	// must cast radius as float to multiply later
+	var la1, lo1, la2, lo2, r float64
+	la1 = lat1 * math.Pi / 180
+	lo1 = lon1 * math.Pi / 180
+	la2 = lat2 * math.Pi / 180
+	lo2 = lon2 * math.Pi / 180
+
+	r = 6378100 // Earth radius in METERS
+
+	// calculate
+	h := hsin(la2-la1) + math.Cos(la1)*math.Cos(la2)*hsin(lo2-lo1)
+
+	return 2 * r * math.Asin(math.Sqrt(h))
+}
+
+//this is called by *** distance(float64, float64, float64, float64) float64 *** do no call yourself, only works on rad
+func hsin(theta float64) float64 {
+	return math.Pow(math.Sin(theta/2), 2)
+}
+
+//routeOffset returns how far along the route in meters the point lies, measured at its closest position on the route
+func routeOffset(route []Coordinates, point Coordinates) float64 {
+	best, bestDistance := 0.0, math.Inf(1)
+	covered := 0.0
+	for i := 1; i < len(route); i++ {
+		a, b := route[i-1], route[i]
+		//equirectangular projection is precise enough for the length of a route segment
+		scale := math.Cos(point.Lat * math.Pi / 180)
+		ax, ay := a.Lon*scale, a.Lat
+		bx, by := b.Lon*scale, b.Lat
+		px, py := point.Lon*scale, point.Lat
+		fraction := 0.0
+		if lengthSquared := (bx-ax)*(bx-ax) + (by-ay)*(by-ay); lengthSquared > 0 {
+			fraction = math.Max(0, math.Min(1, ((px-ax)*(bx-ax)+(py-ay)*(by-ay))/lengthSquared))
+		}
+		projected := Coordinates{Lat: a.Lat + (b.Lat-a.Lat)*fraction, Lon: a.Lon + (b.Lon-a.Lon)*fraction}
+		length := Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+		//strictly closer, so a route passing the same spot twice keeps the earlier pass
+		if distance := Distance(point.Lat, point.Lon, projected.Lat, projected.Lon); distance < bestDistance {
+			best, bestDistance = covered+length*fraction, distance
+		}
+		covered += length
+	}
+	return best
+}
+
+//pathLength returns the length in meters along the points
+func pathLength(points []Coordinates) float64 {
+	length := 0.0
+	for i := 1; i < len(points); i++ {
+		length += Distance(points[i-1].Lat, points[i-1].Lon, points[i].Lat, points[i].Lon)
+	}
+	return length
+}
+
+//interpolatedBetween reports whether the route between the offsets overlaps a leg interpolated through a gap
+func interpolatedBetween(legs []RouteLeg, from float64, to float64) bool {
+	covered := 0.0
+	for _, leg := range legs {
+		start, end := covered, covered+leg.Distance
+		covered = end
+		if leg.Interpolated && from < end && to > start {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//geoJSONGeometry is a geojson geometry as returned by ST_AsGeoJSON, positions are [lon, lat]
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []geoJSONGeometry `json:"geometries"`
+}
+
+//parseLines decodes a geojson geometry and returns its contiguous line pieces
+func parseLines(geoJSON string) ([][]Coordinates, error) {
+	var geometry geoJSONGeometry
+	if err := json.Unmarshal([]byte(geoJSON), &geometry); err != nil {
+		return nil, err
+	}
+	return geometry.lines()
+}
+
+//lines returns the line pieces of the geometry. A route only touching a zone border intersects it
+//in single points, these have no length and are dropped.
+func (g geoJSONGeometry) lines() ([][]Coordinates, error) {
+	switch g.Type {
+	case "LineString":
+		var positions [][]float64
+		if err := json.Unmarshal(g.Coordinates, &positions); err != nil {
+			return nil, err
+		}
+		return [][]Coordinates{toCoordinates(positions)}, nil
+	case "MultiLineString":
+		var lines [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &lines); err != nil {
+			return nil, err
+		}
+		pieces := make([][]Coordinates, 0, len(lines))
+		for _, positions := range lines {
+			pieces = append(pieces, toCoordinates(positions))
+		}
+		return pieces, nil
+	case "GeometryCollection":
+		var pieces [][]Coordinates
+		for _, geometry := range g.Geometries {
+			lines, err := geometry.lines()
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, lines...)
+		}
+		return pieces, nil
+	case "Point", "MultiPoint":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+}
+
+func toCoordinates(positions [][]float64) []Coordinates {
+	coordinates := make([]Coordinates, 0, len(positions))
+	for _, position := range positions {
+		if len(position) < 2 {
+			continue
+		}
+		coordinates = append(coordinates, Coordinates{
+			Lat: position[1],
+			Lon: position[0],
+		})
+	}
+	return coordinates
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseLines(t *testing.T) {
+	cases := []struct {
+		name    string
+		geoJSON string
+		want    [][]Coordinates
+		ok      bool
+	}{
+		{"line string west of greenwich and south of the equator",
+			`{"type":"LineString","coordinates":[[-58.38,-34.6],[-58.37,-34.61]]}`,
+			[][]Coordinates{{{Lat: -34.6, Lon: -58.38}, {Lat: -34.61, Lon: -58.37}}}, true},
+		{"multi line string",
+			`{"type":"MultiLineString","coordinates":[[[13.4,52.5],[13.41,52.5]],[[13.42,52.51],[13.43,52.51],[13.43,52.52]]]}`,
+			[][]Coordinates{
+				{{Lat: 52.5, Lon: 13.4}, {Lat: 52.5, Lon: 13.41}},
+				{{Lat: 52.51, Lon: 13.42}, {Lat: 52.51, Lon: 13.43}, {Lat: 52.52, Lon: 13.43}},
+			}, true},
+		{"collection touching the border in a point",
+			`{"type":"GeometryCollection","geometries":[
+				{"type":"Point","coordinates":[13.39,52.5]},
+				{"type":"LineString","coordinates":[[13.4,52.5],[13.41,52.5]]},
+				{"type":"MultiLineString","coordinates":[[[13.42,52.51],[13.43,52.51]]]}]}`,
+			[][]Coordinates{
+				{{Lat: 52.5, Lon: 13.4}, {Lat: 52.5, Lon: 13.41}},
+				{{Lat: 52.51, Lon: 13.42}, {Lat: 52.51, Lon: 13.43}},
+			}, true},
+		{"positions with elevation", `{"type":"LineString","coordinates":[[13.4,52.5,34],[13.41,52.5,35]]}`,
+			[][]Coordinates{{{Lat: 52.5, Lon: 13.4}, {Lat: 52.5, Lon: 13.41}}}, true},
+		{"only points", `{"type":"MultiPoint","coordinates":[[13.4,52.5]]}`, nil, true},
+		{"empty collection", `{"type":"GeometryCollection","geometries":[]}`, nil, true},
+		{"polygon", `{"type":"Polygon","coordinates":[[[13.4,52.5],[13.41,52.5],[13.41,52.51],[13.4,52.5]]]}`, nil, false},
+		{"collection with a polygon", `{"type":"GeometryCollection","geometries":[{"type":"Polygon","coordinates":[]}]}`, nil, false},
+		{"malformed coordinates", `{"type":"LineString","coordinates":[13.4,52.5]}`, nil, false},
+		{"no json", `LINESTRING(13.4 52.5,13.41 52.5)`, nil, false},
+	}
+	for _, c := range cases {
+		lines, err := parseLines(c.geoJSON)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok %v", c.name, err, c.ok)
+			continue
+		}
+		if fmt.Sprint(lines) != fmt.Sprint(c.want) {
+			t.Errorf("%s: lines = %v, want %v", c.name, lines, c.want)
+		}
+	}
+}
+
+func TestParseLinesKeepsLatAndLonApart(t *testing.T) {
+	//geojson positions are [lon, lat], a swap would put Berlin into the Indian Ocean
+	lines, err := parseLines(`{"type":"LineString","coordinates":[[13.4,52.5]]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || len(lines[0]) != 1 {
+		t.Fatalf("lines = %v, want one position", lines)
+	}
+	if position := lines[0][0]; position.Lat != 52.5 || position.Lon != 13.4 {
+		t.Errorf("position = %+v, want lat 52.5 and lon 13.4", position)
+	}
+}
@@ -1,18 +1,15 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
-	"regexp"
+	"sort"
 	"strconv"
 	"time"
 
-	_ "github.com/lib/pq"
-
 	micro "github.com/micro/go-micro"
 	nats "github.com/nats-io/go-nats"
 )
@@ -24,7 +21,7 @@ var (
 	publishQueueName   = "pollution.matched"
 	globalNatsConn     *nats.Conn
 	logQueueName       = "logs"
-	globalDbConn       *sql.DB
+	zoneStore          ZoneStore
 )
 
 //Coordinates Struct to unite a Latitude and Longitude to one location
@@ -35,19 +32,65 @@ type Coordinates struct {
 
 //Segment is a polluted area and defined by a polygon between segment sections
 type Segment struct {
-	SegmentID       int           `json:"segmentId"`
+	SegmentID       string        `json:"segmentId"` // message id, zone id and ordinal, e.g. 4711-12-0
+	ZoneID          int64         `json:"zoneId"`
+	Ordinal         int           `json:"ordinal"`   // position of the segment along the route, starting at 0
+	Offset          float64       `json:"offset"`    // meters from the route start to where the segment begins
+	EnteredAt       string        `json:"enteredAt"` // when the car entered the zone, its level is the one in force then
 	PollutionLevel  int           `json:"pollutionLevel"`
+	Interpolated    bool          `json:"interpolated"` // the segment lies on a leg interpolated through a gap, e.g. a tunnel
+	SegmentSections []Coordinates `json:"segmentSections"`
+}
+
+//UnmatchedSegment is a part of the route within a zone whose level could not be determined, it is not charged
+type UnmatchedSegment struct {
+	ZoneID          int64         `json:"zoneId"`
+	Offset          float64       `json:"offset"`
+	Reason          string        `json:"reason"`
 	SegmentSections []Coordinates `json:"segmentSections"`
 }
 
 // MapMatcherMessage struct
 type MapMatcherMessage struct {
-	MessageID int           `json:"messageId"`
-	CarID     string        `json:"carId"`
-	Timestamp string        `json:"timestamp"`
-	Route     []Coordinates `json:"route"`
-	Sender    string        `json:"sender"`
-	Topic     string        `json:"topic"`
+	MessageID            int           `json:"messageId"`
+	CarID                string        `json:"carId"`
+	TripID               string        `json:"tripId"`
+	VehicleType          string        `json:"vehicleType"`
+	Timestamp            string        `json:"timestamp"`
+	ReportTimestamp      string        `json:"reportTimestamp"`
+	Route                []Coordinates `json:"route"`
+	Legs                 []RouteLeg    `json:"legs"`
+	Distance             float64       `json:"distance"`
+	Duration             float64       `json:"duration"`
+	Confidence           float64       `json:"confidence"`
+	LowConfidence        bool          `json:"lowConfidence"`
+	Interpolated         bool          `json:"interpolated"`
+	InterpolatedDistance float64       `json:"interpolatedDistance"`
+	Sender               string        `json:"sender"`
+	Topic                string        `json:"topic"`
+}
+
+//RouteLeg is the part of the matched route between two consecutive tracepoints
+type RouteLeg struct {
+	Distance     float64       `json:"distance"`
+	Duration     float64       `json:"duration"`
+	Roads        []RoadSection `json:"roads"`
+	Interpolated bool          `json:"interpolated,omitempty"`
+	Start        string        `json:"start,omitempty"`
+	End          string        `json:"end,omitempty"`
+}
+
+//RoadSection is the part of a leg driven on a single road
+type RoadSection struct {
+	Name      string   `json:"name"`
+	Ref       string   `json:"ref,omitempty"`
+	WayID     int64    `json:"wayId,omitempty"`
+	NodeIDs   []int64  `json:"nodeIds,omitempty"`
+	RoadClass string   `json:"roadClass,omitempty"`
+	Classes   []string `json:"classes,omitempty"`
+	MaxSpeed  float64  `json:"maxSpeed,omitempty"` // speed limit in km/h, 0 if unknown
+	Distance  float64  `json:"distance"`
+	Duration  float64  `json:"duration"`
 }
 
 //MapMatcherOutput message
@@ -75,12 +118,17 @@ type LogMessageData struct {
 
 //PollutionMatcherMessage Data the pollution matcher is sending after processing
 type PollutionMatcherMessage struct {
-	MessageID int       `json:"messageId"`
-	CarID     string    `json:"carId"`
-	Timestamp string    `json:"timestamp"`
-	Segments  []Segment `json:"segments"`
-	Sender    string    `json:"sender"`
-	Topic     string    `json:"topic"`
+	MessageID     int                `json:"messageId"`
+	CarID         string             `json:"carId"`
+	TripID        string             `json:"tripId"`
+	Timestamp     string             `json:"timestamp"`
+	Segments      []Segment          `json:"segments"`
+	Unmatched     []UnmatchedSegment `json:"unmatched,omitempty"`
+	Confidence    float64            `json:"confidence"`
+	LowConfidence bool               `json:"lowConfidence"`
+	Interpolated  bool               `json:"interpolated"` // one of the segments lies on a leg interpolated through a gap
+	Sender        string             `json:"sender"`
+	Topic         string             `json:"topic"`
 }
 
 func (m PollutionMatcherMessage) toString() string {
@@ -96,13 +144,6 @@ func (m PollutionMatcherOutput) toString() string {
 	return fmt.Sprintf("%+v\n", m)
 }
 
-//GEOJSON Data from postgis
-
-type PGData struct {
-	pollution   int
-	coordinates []string
-}
-
 func main() {
 
 	service := micro.NewService(
@@ -121,9 +162,35 @@ func main() {
 
 	time.Sleep(20 * time.Second)
 
-	db, err := sql.Open("postgres", connStr)
-	checkErr(err)
-	globalDbConn = db
+	if conns, err := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNS")); err == nil {
+		dbMaxOpenConns = conns
+	}
+	if conns, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS")); err == nil {
+		dbMaxIdleConns = conns
+	}
+	if lifetime, err := time.ParseDuration(os.Getenv("DB_CONN_MAX_LIFETIME")); err == nil {
+		dbConnMaxLifetime = lifetime
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("DB_QUERY_TIMEOUT")); err == nil {
+		dbQueryTimeout = timeout
+	}
+	if interval, err := time.ParseDuration(os.Getenv("ZONES_REFRESH_INTERVAL")); err == nil {
+		zonesRefreshInterval = interval
+	}
+	if age, err := time.ParseDuration(os.Getenv("LIVE_LEVEL_MAX_AGE")); err == nil {
+		liveLevelMaxAge = age
+	}
+	if name := os.Getenv("ZONE_TIMEZONE"); name != "" {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		zoneLocation = location
+	}
+	zoneStore, err = newZoneStore(nc)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	nc.Subscribe(subscribeQueueName, func(m *nats.Msg) {
 		fmt.Printf("---Received a message:---\n%s\n", string(m.Data))
@@ -173,76 +240,90 @@ func logMessage(MessageID int, msgType string) {
 }
 
 func processMessage(msg MapMatcherMessage) {
-	if len(msg.Route) < 1 {
+	if len(msg.Route) < 2 {
+		//a single point has no length within any zone
+		fmt.Printf("---route of message %d has less than two points, nothing to match---\n", msg.MessageID)
 		return
 	}
-	rows, err := globalDbConn.Query("select  ST_AsGeoJson(ST_intersection (a.outline, ST_GeomFromText('LINESTRING(" + strconv.FormatFloat(msg.Route[0].Lon, 'f', -1, 64) + " " + strconv.FormatFloat(msg.Route[0].Lat, 'f', -1, 64) + ", " + strconv.FormatFloat(msg.Route[1].Lon, 'f', -1, 64) + " " + strconv.FormatFloat(msg.Route[1].Lat, 'f', -1, 64) + ")', 4326))) as geometry, a.pollution FROM berlin_polygons a WHERE not ST_IsEmpty(ST_AsText(ST_intersection (a.outline, ST_GeomFromText('LINESTRING(" + strconv.FormatFloat(msg.Route[0].Lon, 'f', -1, 64) + " " + strconv.FormatFloat(msg.Route[0].Lat, 'f', -1, 64) + ", " + strconv.FormatFloat(msg.Route[1].Lon, 'f', -1, 64) + " " + strconv.FormatFloat(msg.Route[1].Lat, 'f', -1, 64) + ")',4326))));")
+	segments, unmatched, err := segmentsFor(zoneStore, msg)
 	if err != nil {
-		fmt.Println("----db query error----")
+		fmt.Println("----zone query error----")
 		fmt.Println(err)
+		return
+	}
+	for _, crossing := range unmatched {
+		fmt.Printf("---crossing of zone %d in message %d is unmatched: %s---\n", crossing.ZoneID, msg.MessageID, crossing.Reason)
+	}
+	interpolated := false
+	for _, segment := range segments {
+		interpolated = interpolated || segment.Interpolated
 	}
 
-	var pgDataPoints []PGData
-
-	for rows.Next() { //evaluate|extract db result
-		var pol int
-		var str string
-		err = rows.Scan(&str, &pol)
-		if err != nil {
-			fmt.Println("---row scan error---")
-			fmt.Println(err)
-		}
-
-		re := regexp.MustCompile("[0-9]+.[0-9]+")
-		res := re.FindAllString(str, -1)
-
-		pgData := PGData{
-			pollution:   pol,
-			coordinates: res,
-		}
+	msgData := PollutionMatcherMessage{
+		Topic:         "pollution.matched",
+		Sender:        "GoMicro-PollutionMatcher",
+		MessageID:     msg.MessageID,
+		CarID:         msg.CarID,
+		TripID:        msg.TripID,
+		Timestamp:     time.Now().Local().Format(time.RFC3339),
+		Segments:      segments,
+		Unmatched:     unmatched,
+		Confidence:    msg.Confidence,
+		LowConfidence: msg.LowConfidence,
+		Interpolated:  interpolated,
+	}
+	publishPollutionMatcherMessage(msgData)
+}
 
-		pgDataPoints = append(pgDataPoints, pgData)
+//segmentsFor returns the zone segments of the route in driving order, a zone crossed more than once
+//has one segment per crossing. Crossings without a known time are returned as unmatched, neither the
+//validity nor the level of the zone can be told without it.
+func segmentsFor(store ZoneStore, msg MapMatcherMessage) ([]Segment, []UnmatchedSegment, error) {
+	intersections, err := store.Intersect(context.Background(), msg.Route)
+	if err != nil {
+		return nil, nil, err
 	}
-	var segments []Segment
-	for _, point := range pgDataPoints {
-		if len(point.coordinates) < 1 {
-			return
-		}
-		latOne, err := strconv.ParseFloat(point.coordinates[0], 64)
-		lonOne, err2 := strconv.ParseFloat(point.coordinates[1], 64)
-		latTwo, err3 := strconv.ParseFloat(point.coordinates[2], 64)
-		lonTwo, err4 := strconv.ParseFloat(point.coordinates[3], 64)
 
-		if err != nil || err2 != nil || err3 != nil || err4 != nil {
-			fmt.Println("---float parsing error in segment generation---")
-		}
-		segment := Segment{
-
-			SegmentID:      rand.Intn(10000000000),
-			PollutionLevel: point.pollution,
-			SegmentSections: []Coordinates{
-				Coordinates{
-					Lat: latOne,
-					Lon: lonOne,
-				},
-				Coordinates{
-					Lat: latTwo,
-					Lon: lonTwo,
-				},
-			},
+	var segments []Segment
+	var unmatched []UnmatchedSegment
+	for _, intersection := range intersections {
+		for _, piece := range intersection.Lines {
+			if len(piece) < 2 {
+				continue
+			}
+			offset := routeOffset(msg.Route, piece[0])
+			entered, err := eventTime(msg, offset)
+			if err != nil {
+				unmatched = append(unmatched, UnmatchedSegment{
+					ZoneID:          intersection.ZoneID,
+					Offset:          offset,
+					Reason:          err.Error(),
+					SegmentSections: piece,
+				})
+				continue
+			}
+			if !validOn(intersection.ValidFrom, intersection.ValidUntil, entered) {
+				continue
+			}
+			segments = append(segments, Segment{
+				ZoneID:          intersection.ZoneID,
+				Offset:          offset,
+				EnteredAt:       entered.Format(time.RFC3339),
+				PollutionLevel:  levelAt(intersection.Pollution, intersection.Schedule, intersection.Live, entered),
+				Interpolated:    interpolatedBetween(msg.Legs, offset, offset+pathLength(piece)),
+				SegmentSections: piece,
+			})
 		}
-		segments = append(segments, segment)
 	}
-
-	msgData := PollutionMatcherMessage{
-		Topic:     "pollution.matched",
-		Sender:    "GoMicro-PollutionMatcher",
-		MessageID: msg.MessageID,
-		CarID:     msg.CarID,
-		Timestamp: time.Now().Local().Format(time.RFC3339),
-		Segments:  segments,
+	//the zones come in table order, the segments are numbered in driving order
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Offset < segments[j].Offset
+	})
+	for i := range segments {
+		segments[i].Ordinal = i
+		segments[i].SegmentID = segmentID(msg.MessageID, segments[i].ZoneID, i)
 	}
-	publishPollutionMatcherMessage(msgData)
+	return segments, unmatched, nil
 }
 
 func publishPollutionMatcherMessage(msg PollutionMatcherMessage) {
@@ -262,8 +343,8 @@ func publishPollutionMatcherMessage(msg PollutionMatcherMessage) {
 	fmt.Println("--- Publishing process completed ---")
 }
 
-func checkErr(err error) {
-	if err != nil {
-		fmt.Print(err)
-	}
+//segmentID derives the id of a segment from the input message, the zone it lies in and its position along
+//the route, so the same input always yields the same ids
+func segmentID(messageID int, zoneID int64, ordinal int) string {
+	return fmt.Sprintf("%d-%d-%d", messageID, zoneID, ordinal)
 }
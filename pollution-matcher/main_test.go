@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSegmentsForOrdinalsAndOffsets(t *testing.T) {
+	//heading east through zone 2, then through zone 1 around its hole on an interpolated leg
+	route := []Coordinates{{Lat: 52.51, Lon: 13.37}, {Lat: 52.51, Lon: 13.40}, {Lat: 52.51, Lon: 13.43}}
+	start := time.Date(2026, 5, 4, 8, 0, 0, 0, time.UTC)
+	msg := MapMatcherMessage{
+		MessageID: 7,
+		Route:     route,
+		Legs: []RouteLeg{
+			{Distance: pathLength(route[:2]), Start: start.Format(time.RFC3339), End: start.Add(2 * time.Minute).Format(time.RFC3339)},
+			{Distance: pathLength(route[1:]), Start: start.Add(2 * time.Minute).Format(time.RFC3339), End: start.Add(4 * time.Minute).Format(time.RFC3339), Interpolated: true},
+		},
+	}
+
+	segments, unmatched, err := segmentsFor(testIndex(t), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmatched) != 0 {
+		t.Errorf("unmatched segments %+v, want none", unmatched)
+	}
+	want := []struct {
+		id           string
+		zoneID       int64
+		lon          float64
+		level        int
+		interpolated bool
+	}{
+		{"7-2-0", 2, 13.38, 5, false},
+		{"7-1-1", 1, 13.40, 3, true},
+		{"7-1-2", 1, 13.415, 3, true},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("%d segments, want %d: %+v", len(segments), len(want), segments)
+	}
+	for i, w := range want {
+		segment := segments[i]
+		if segment.Ordinal != i || segment.SegmentID != w.id || segment.ZoneID != w.zoneID {
+			t.Errorf("segment %d is %s of zone %d with ordinal %d, want %s", i, segment.SegmentID, segment.ZoneID, segment.Ordinal, w.id)
+		}
+		offset := Distance(52.51, 13.37, 52.51, w.lon)
+		if math.Abs(segment.Offset-offset) > 1 {
+			t.Errorf("segment %s offset = %.1f, want %.1f", w.id, segment.Offset, offset)
+		}
+		if segment.PollutionLevel != w.level || segment.Interpolated != w.interpolated {
+			t.Errorf("segment %s level %d interpolated %t, want %d and %t", w.id, segment.PollutionLevel, segment.Interpolated, w.level, w.interpolated)
+		}
+	}
+	if entered := segments[1].EnteredAt; entered != start.Add(2*time.Minute).Format(time.RFC3339) {
+		t.Errorf("zone 1 entered at %s, want at the end of the first leg", entered)
+	}
+}
+
+func TestSegmentsForWithoutTime(t *testing.T) {
+	msg := MapMatcherMessage{
+		MessageID: 8,
+		Route:     []Coordinates{{Lat: 52.51, Lon: 13.37}, {Lat: 52.51, Lon: 13.395}},
+		Legs:      []RouteLeg{{Distance: 1700}},
+	}
+	segments, unmatched, err := segmentsFor(testIndex(t), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 0 {
+		t.Errorf("segments %+v, want none without a time", segments)
+	}
+	if len(unmatched) != 1 || unmatched[0].ZoneID != 2 || unmatched[0].Reason == "" {
+		t.Errorf("unmatched = %+v, want the crossing of zone 2 with a reason", unmatched)
+	}
+
+	msg.ReportTimestamp = "2026-05-04T08:00:00Z"
+	if segments, unmatched, _ = segmentsFor(testIndex(t), msg); len(segments) != 1 || len(unmatched) != 0 {
+		t.Errorf("%d segments and %d unmatched with a report timestamp, want 1 and 0", len(segments), len(unmatched))
+	}
+}
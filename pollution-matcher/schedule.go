@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+var (
+	//zoneLocation is the time zone the level rules are written in
+	zoneLocation = time.Local
+	//liveLevelMaxAge is how long before and after its newest reading a level measured by the sensors counts
+	liveLevelMaxAge = time.Hour
+	weekdays        = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+)
+
+//LevelRule sets the pollution level of a zone while it applies. A window whose end is before its start
+//wraps midnight and belongs to the day it starts on. Empty fields do not restrict the rule, a rule for the
+//whole day leaves from and to empty.
+type LevelRule struct {
+	Pollution  int      `json:"pollution"`
+	Days       []string `json:"days,omitempty"`       // mon, tue, wed, thu, fri, sat or sun
+	From       string   `json:"from,omitempty"`       // HH:MM
+	To         string   `json:"to,omitempty"`         // HH:MM, exclusive
+	ValidFrom  string   `json:"validFrom,omitempty"`  // YYYY-MM-DD
+	ValidUntil string   `json:"validUntil,omitempty"` // YYYY-MM-DD, inclusive
+}
+
+//validate checks the rule, rules are only applied after they passed
+func (r LevelRule) validate() error {
+	if r.Pollution < 1 || r.Pollution > 9 {
+		return fmt.Errorf("pollution level %d is not between 1 and 9", r.Pollution)
+	}
+	for _, day := range r.Days {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+	}
+	for _, clock := range []string{r.From, r.To} {
+		if _, err := parseClock(clock, 0); err != nil {
+			return err
+		}
+	}
+	//equal times would be read as a window of 24 hours wrapping midnight
+	if r.From != "" && r.From == r.To {
+		return fmt.Errorf("window from %s to %s is empty, leave both out for the whole day", r.From, r.To)
+	}
+	for _, date := range []string{r.ValidFrom, r.ValidUntil} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//applies reports whether the rule is in force at the time
+func (r LevelRule) applies(t time.Time) bool {
+	t = t.In(zoneLocation)
+	if !validOn(r.ValidFrom, r.ValidUntil, t) {
+		return false
+	}
+	from, _ := parseClock(r.From, 0)
+	to, _ := parseClock(r.To, 24*60)
+	minute := t.Hour()*60 + t.Minute()
+	if from < to {
+		return minute >= from && minute < to && r.onDay(t.Weekday())
+	}
+	if minute >= from {
+		return r.onDay(t.Weekday())
+	}
+	if minute < to {
+		return r.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (r LevelRule) onDay(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, name := range r.Days {
+		if weekdays[name] == day {
+			return true
+		}
+	}
+	return false
+}
+
+//validOn reports whether the day of the time lies within the YYYY-MM-DD dates, empty dates are open ends
+func validOn(validFrom string, validUntil string, t time.Time) bool {
+	date := t.In(zoneLocation).Format("2006-01-02")
+	return (validFrom == "" || date >= validFrom) && (validUntil == "" || date <= validUntil)
+}
+
+//parseClock returns the minutes since midnight of a HH:MM time, fallback if it is empty
+func parseClock(clock string, fallback int) (int, error) {
+	if clock == "" {
+		return fallback, nil
+	}
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return fallback, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+//LiveLevel is the level the sensors measured in a zone, Pollution is 0 if there is none
+type LiveLevel struct {
+	Pollution  int
+	MeasuredAt time.Time // newest reading the level is based on
+}
+
+//levelAt returns the live level if it was measured within liveLevelMaxAge of the time, else the level of the
+//first rule in force, else the static level. Measurements win as they tell the air the car drove through,
+//the rules only plan for it.
+func levelAt(pollution int, schedule []LevelRule, live LiveLevel, t time.Time) int {
+	if live.Pollution > 0 {
+		age := t.Sub(live.MeasuredAt)
+		if age < liveLevelMaxAge && age > -liveLevelMaxAge {
+			return live.Pollution
+		}
+	}
+	for _, rule := range schedule {
+		if rule.applies(t) {
+			return rule.Pollution
+		}
+	}
+	return pollution
+}
+
+//parseSchedule decodes the json list of level rules and drops the invalid ones
+func parseSchedule(zoneID int64, schedule []byte) []LevelRule {
+	if len(schedule) == 0 {
+		return nil
+	}
+	var rules []LevelRule
+	if err := json.Unmarshal(schedule, &rules); err != nil {
+		fmt.Printf("---invalid schedule of zone %d---\n", zoneID)
+		fmt.Println(err)
+		return nil
+	}
+	valid := rules[:0]
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			fmt.Printf("---invalid level rule of zone %d---\n", zoneID)
+			fmt.Println(err)
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid
+}
+
+//eventTime returns when the car passed the point offset meters along the route, interpolated between the
+//report timestamps of the leg it lies on. Routes without timed legs fall back to the latest report, without
+//that it is unknown and an error is returned.
+func eventTime(msg MapMatcherMessage, offset float64) (time.Time, error) {
+	covered := 0.0
+	for i, leg := range msg.Legs {
+		if offset > covered+leg.Distance && i < len(msg.Legs)-1 {
+			covered += leg.Distance
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, leg.Start)
+		if err != nil {
+			break
+		}
+		end, err := time.Parse(time.RFC3339, leg.End)
+		if err != nil {
+			break
+		}
+		fraction := 1.0
+		if leg.Distance > 0 {
+			fraction = (offset - covered) / leg.Distance
+		}
+		if fraction < 0 {
+			fraction = 0
+		} else if fraction > 1 {
+			fraction = 1
+		}
+		return start.Add(time.Duration(fraction * float64(end.Sub(start)))), nil
+	}
+	reported, err := time.Parse(time.RFC3339, msg.ReportTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("no timed leg and no report timestamp: %v", err)
+	}
+	return reported, nil
+}
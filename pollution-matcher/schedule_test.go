@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+//onMay returns the time on the day of May 2026 in UTC, the 4th is a monday
+func onMay(day int, hour int, minute int) time.Time {
+	return time.Date(2026, 5, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestLevelRuleApplies(t *testing.T) {
+	defer func(location *time.Location) { zoneLocation = location }(zoneLocation)
+	zoneLocation = time.UTC
+
+	rushHour := LevelRule{Pollution: 7, From: "07:00", To: "09:00"}
+	fridayNight := LevelRule{Pollution: 8, Days: []string{"fri"}, From: "22:00", To: "06:00"}
+	cases := []struct {
+		name string
+		rule LevelRule
+		at   time.Time
+		want bool
+	}{
+		{"within the window", rushHour, onMay(4, 8, 0), true},
+		{"at the start", rushHour, onMay(4, 7, 0), true},
+		{"end is exclusive", rushHour, onMay(4, 9, 0), false},
+		{"before midnight of a wrapping window", fridayNight, onMay(8, 23, 0), true},
+		{"after midnight belongs to the day it started", fridayNight, onMay(9, 5, 59), true},
+		{"after the end of a wrapping window", fridayNight, onMay(9, 6, 0), false},
+		{"after midnight of the day before", fridayNight, onMay(8, 5, 0), false},
+		{"other day", LevelRule{Pollution: 5, Days: []string{"sat", "sun"}}, onMay(4, 12, 0), false},
+		{"listed day", LevelRule{Pollution: 5, Days: []string{"sat", "sun"}}, onMay(10, 12, 0), true},
+		{"without restrictions", LevelRule{Pollution: 5}, onMay(4, 0, 0), true},
+		{"before valid from", LevelRule{Pollution: 5, ValidFrom: "2026-05-05"}, onMay(4, 23, 59), false},
+		{"on valid from", LevelRule{Pollution: 5, ValidFrom: "2026-05-05"}, onMay(5, 0, 0), true},
+		{"valid until is inclusive", LevelRule{Pollution: 5, ValidUntil: "2026-05-04"}, onMay(4, 23, 59), true},
+		{"after valid until", LevelRule{Pollution: 5, ValidUntil: "2026-05-04"}, onMay(5, 0, 0), false},
+	}
+	for _, c := range cases {
+		if applies := c.rule.applies(c.at); applies != c.want {
+			t.Errorf("%s: applies at %s = %t, want %t", c.name, c.at.Format(time.RFC3339), applies, c.want)
+		}
+	}
+}
+
+func TestLevelRuleApplyInZoneTime(t *testing.T) {
+	defer func(location *time.Location) { zoneLocation = location }(zoneLocation)
+	zoneLocation = time.FixedZone("CEST", 2*60*60)
+
+	//06:30 UTC is 08:30 in the zone
+	if rule := (LevelRule{Pollution: 7, From: "08:00", To: "09:00"}); !rule.applies(onMay(4, 6, 30)) {
+		t.Error("rule read in UTC instead of the time zone of the zones")
+	}
+}
+
+func TestLevelRuleValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		rule  LevelRule
+		valid bool
+	}{
+		{"complete rule", LevelRule{Pollution: 7, Days: []string{"mon"}, From: "07:00", To: "09:00", ValidFrom: "2026-01-01", ValidUntil: "2026-12-31"}, true},
+		{"level too low", LevelRule{Pollution: 0}, false},
+		{"level too high", LevelRule{Pollution: 10}, false},
+		{"unknown day", LevelRule{Pollution: 5, Days: []string{"monday"}}, false},
+		{"invalid clock", LevelRule{Pollution: 5, From: "25:00"}, false},
+		{"invalid date", LevelRule{Pollution: 5, ValidUntil: "2026-13-01"}, false},
+		{"equal from and to", LevelRule{Pollution: 5, From: "08:00", To: "08:00"}, false},
+		{"from until midnight", LevelRule{Pollution: 5, From: "22:00"}, true},
+	}
+	for _, c := range cases {
+		if err := c.rule.validate(); (err == nil) != c.valid {
+			t.Errorf("%s: validate = %v, want valid %t", c.name, err, c.valid)
+		}
+	}
+}
+
+func TestLevelAtPrecedence(t *testing.T) {
+	defer func(location *time.Location) { zoneLocation = location }(zoneLocation)
+	zoneLocation = time.UTC
+	//a monday morning within the rush hour rule
+	at := time.Date(2026, 5, 4, 8, 0, 0, 0, time.UTC)
+	schedule := []LevelRule{{Pollution: 7, Days: []string{"mon"}, From: "07:00", To: "09:00"}}
+
+	cases := []struct {
+		name string
+		live LiveLevel
+		want int
+	}{
+		{"no live level", LiveLevel{}, 7},
+		{"fresh live level", LiveLevel{Pollution: 4, MeasuredAt: at.Add(-10 * time.Minute)}, 4},
+		{"measured after the time", LiveLevel{Pollution: 4, MeasuredAt: at.Add(10 * time.Minute)}, 4},
+		{"stale live level", LiveLevel{Pollution: 4, MeasuredAt: at.Add(-liveLevelMaxAge)}, 7},
+	}
+	for _, c := range cases {
+		if level := levelAt(3, schedule, c.live, at); level != c.want {
+			t.Errorf("%s: level %d, want %d", c.name, level, c.want)
+		}
+	}
+	if level := levelAt(3, schedule, LiveLevel{}, at.Add(2*time.Hour)); level != 3 {
+		t.Errorf("outside the rule: level %d, want the static 3", level)
+	}
+}
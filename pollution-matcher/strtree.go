@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/paulmach/orb"
+)
+
+const strNodeCapacity = 10
+
+//strTree is a static R-tree bulk loaded with the sort-tile-recursive algorithm. It cannot be
+//updated, the zone index builds a new one on every refresh.
+type strTree struct {
+	root *strNode
+}
+
+type strNode struct {
+	bound    orb.Bound
+	children []*strNode
+	item     int // index of the bound the leaf was built from, -1 for inner nodes
+}
+
+func newSTRTree(bounds []orb.Bound) *strTree {
+	if len(bounds) == 0 {
+		return &strTree{}
+	}
+	nodes := make([]*strNode, 0, len(bounds))
+	for i, bound := range bounds {
+		nodes = append(nodes, &strNode{bound: bound, item: i})
+	}
+	for len(nodes) > 1 {
+		nodes = packLevel(nodes)
+	}
+	return &strTree{root: nodes[0]}
+}
+
+//packLevel sorts the nodes into vertical slices by x, each slice by y, and groups neighbours under a parent
+func packLevel(nodes []*strNode) []*strNode {
+	parents := int(math.Ceil(float64(len(nodes)) / strNodeCapacity))
+	sliceSize := int(math.Ceil(math.Sqrt(float64(parents)))) * strNodeCapacity
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].bound.Center().X() < nodes[j].bound.Center().X()
+	})
+
+	packed := make([]*strNode, 0, parents)
+	for start := 0; start < len(nodes); start += sliceSize {
+		slice := nodes[start:int(math.Min(float64(start+sliceSize), float64(len(nodes))))]
+		sort.Slice(slice, func(i, j int) bool {
+			return slice[i].bound.Center().Y() < slice[j].bound.Center().Y()
+		})
+		for first := 0; first < len(slice); first += strNodeCapacity {
+			children := slice[first:int(math.Min(float64(first+strNodeCapacity), float64(len(slice))))]
+			parent := &strNode{
+				bound:    children[0].bound,
+				children: append([]*strNode{}, children...),
+				item:     -1,
+			}
+			for _, child := range children[1:] {
+				parent.bound = parent.bound.Union(child.bound)
+			}
+			packed = append(packed, parent)
+		}
+	}
+	return packed
+}
+
+//search returns the items whose bound intersects the given one
+func (t *strTree) search(bound orb.Bound) []int {
+	if t.root == nil {
+		return nil
+	}
+	var items []int
+	stack := []*strNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !node.bound.Intersects(bound) {
+			continue
+		}
+		if node.item >= 0 {
+			items = append(items, node.item)
+			continue
+		}
+		stack = append(stack, node.children...)
+	}
+	return items
+}
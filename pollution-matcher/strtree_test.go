@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestSTRTreeSearch(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	//enough bounds for three levels of nodes
+	bounds := make([]orb.Bound, 0, 500)
+	for i := 0; i < 500; i++ {
+		x, y := random.Float64()*100, random.Float64()*100
+		bounds = append(bounds, orb.Bound{Min: orb.Point{x, y}, Max: orb.Point{x + random.Float64()*5, y + random.Float64()*5}})
+	}
+	tree := newSTRTree(bounds)
+
+	for q := 0; q < 200; q++ {
+		x, y := random.Float64()*100, random.Float64()*100
+		query := orb.Bound{Min: orb.Point{x, y}, Max: orb.Point{x + random.Float64()*10, y + random.Float64()*10}}
+		var want []int
+		for i, bound := range bounds {
+			if bound.Intersects(query) {
+				want = append(want, i)
+			}
+		}
+		got := tree.search(query)
+		sort.Ints(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("search %v = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestSTRTreeEmpty(t *testing.T) {
+	if items := newSTRTree(nil).search(orb.Bound{Max: orb.Point{1, 1}}); items != nil {
+		t.Errorf("empty tree found %v", items)
+	}
+}
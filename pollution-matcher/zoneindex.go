@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+var (
+	zonesChangedQueueName = "zones.changed"
+	zonesRefreshInterval  = 5 * time.Minute
+)
+
+//zoneIndex is the ZoneStore keeping all pollution zones in memory with an STR-tree over their bounds.
+//Intersections are computed in the same planar lon/lat space postgis uses for geometries in EPSG:4326.
+type zoneIndex struct {
+	mutex sync.RWMutex
+	zones []Zone
+	tree  *strTree
+}
+
+//load replaces the indexed zones
+func (x *zoneIndex) load(zones []Zone) {
+	bounds := make([]orb.Bound, 0, len(zones))
+	for _, zone := range zones {
+		bounds = append(bounds, zone.Outline.Bound())
+	}
+	tree := newSTRTree(bounds)
+
+	x.mutex.Lock()
+	x.zones = zones
+	x.tree = tree
+	x.mutex.Unlock()
+	fmt.Printf("--- Indexed %d pollution zones ---\n", len(zones))
+}
+
+//refresh reloads the zones, the old ones stay in use if that fails
+func (x *zoneIndex) refresh(load func(ctx context.Context) ([]Zone, error)) error {
+	zones, err := load(context.Background())
+	if err != nil {
+		return err
+	}
+	x.load(zones)
+	return nil
+}
+
+//runRefresh reloads the zones periodically
+func (x *zoneIndex) runRefresh(load func(ctx context.Context) ([]Zone, error), interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := x.refresh(load); err != nil {
+			fmt.Println("---zone refresh error---")
+			fmt.Println(err)
+		}
+	}
+}
+
+//Intersect returns the parts of the route lying within pollution zones
+func (x *zoneIndex) Intersect(ctx context.Context, route []Coordinates) ([]ZoneIntersection, error) {
+	line := make(orb.LineString, 0, len(route))
+	for _, point := range route {
+		line = append(line, orb.Point{point.Lon, point.Lat})
+	}
+
+	x.mutex.RLock()
+	zones, tree := x.zones, x.tree
+	x.mutex.RUnlock()
+	if tree == nil {
+		return nil, nil
+	}
+
+	candidates := tree.search(line.Bound())
+	//in load order, like postgis returns them in table order
+	sort.Ints(candidates)
+	var intersections []ZoneIntersection
+	for _, candidate := range candidates {
+		lines := clipLine(line, zones[candidate].Outline)
+		if len(lines) > 0 {
+			intersections = append(intersections, ZoneIntersection{
+				ZoneID:     zones[candidate].ID,
+				Lines:      lines,
+				Pollution:  zones[candidate].Pollution,
+				Schedule:   zones[candidate].Schedule,
+				Live:       zones[candidate].Live,
+				ValidFrom:  zones[candidate].ValidFrom,
+				ValidUntil: zones[candidate].ValidUntil,
+			})
+		}
+	}
+	return intersections, nil
+}
+
+//ListZones returns all indexed zones
+func (x *zoneIndex) ListZones(ctx context.Context) ([]Zone, error) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return append([]Zone{}, x.zones...), nil
+}
+
+//GetZone returns the zone with the id or ErrZoneNotFound
+func (x *zoneIndex) GetZone(ctx context.Context, id int64) (Zone, error) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	for _, zone := range x.zones {
+		if zone.ID == id {
+			return zone, nil
+		}
+	}
+	return Zone{}, ErrZoneNotFound
+}
+
+//clipLine returns the contiguous pieces of the line inside the outline. Every line segment is cut where it
+//crosses a ring, the parts whose middle lies inside are kept.
+func clipLine(line orb.LineString, outline orb.MultiPolygon) [][]Coordinates {
+	var pieces [][]Coordinates
+	var piece []Coordinates
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		cuts := []float64{0, 1}
+		for _, polygon := range outline {
+			for _, ring := range polygon {
+				for j := range ring {
+					if t, ok := crossing(a, b, ring[j], ring[(j+1)%len(ring)]); ok {
+						cuts = append(cuts, t)
+					}
+				}
+			}
+		}
+		sort.Float64s(cuts)
+
+		for k := 1; k < len(cuts); k++ {
+			if cuts[k]-cuts[k-1] < 1e-12 {
+				continue
+			}
+			if !planar.MultiPolygonContains(outline, interpolate(a, b, (cuts[k-1]+cuts[k])/2)) {
+				if len(piece) >= 2 {
+					pieces = append(pieces, piece)
+				}
+				piece = nil
+				continue
+			}
+			if len(piece) == 0 {
+				piece = append(piece, toCoordinate(interpolate(a, b, cuts[k-1])))
+			}
+			piece = append(piece, toCoordinate(interpolate(a, b, cuts[k])))
+		}
+	}
+	if len(piece) >= 2 {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+//crossing returns where along a-b, as fraction of its length, it crosses c-d. Collinear segments do not cross.
+func crossing(a orb.Point, b orb.Point, c orb.Point, d orb.Point) (float64, bool) {
+	rx, ry := b[0]-a[0], b[1]-a[1]
+	sx, sy := d[0]-c[0], d[1]-c[1]
+	denominator := rx*sy - ry*sx
+	if denominator == 0 {
+		return 0, false
+	}
+	qx, qy := c[0]-a[0], c[1]-a[1]
+	t := (qx*sy - qy*sx) / denominator
+	u := (qx*ry - qy*rx) / denominator
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
+
+func interpolate(a orb.Point, b orb.Point, t float64) orb.Point {
+	return orb.Point{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t}
+}
+
+func toCoordinate(point orb.Point) Coordinates {
+	return Coordinates{Lat: point[1], Lon: point[0]}
+}
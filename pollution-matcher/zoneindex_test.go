@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+//testIndex indexes the zones of testdata/zones.geojson: zone 1 is a square with a square hole,
+//zone 2 a rectangle west of it
+func testIndex(t *testing.T) *zoneIndex {
+	zones, err := loadZonesFile("testdata/zones.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := &zoneIndex{}
+	index.load(zones)
+	return index
+}
+
+func testOutline(t *testing.T, id int64) orb.MultiPolygon {
+	zone, err := testIndex(t).GetZone(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zone.Outline
+}
+
+func lineOf(points ...Coordinates) orb.LineString {
+	line := make(orb.LineString, 0, len(points))
+	for _, point := range points {
+		line = append(line, orb.Point{point.Lon, point.Lat})
+	}
+	return line
+}
+
+//assertPieces compares the pieces within a few centimeters
+func assertPieces(t *testing.T, pieces [][]Coordinates, want [][]Coordinates) {
+	t.Helper()
+	if len(pieces) != len(want) {
+		t.Fatalf("pieces = %v, want %v", pieces, want)
+	}
+	for i := range want {
+		if len(pieces[i]) != len(want[i]) {
+			t.Fatalf("piece %d = %v, want %v", i, pieces[i], want[i])
+		}
+		for j := range want[i] {
+			if math.Abs(pieces[i][j].Lat-want[i][j].Lat) > 1e-7 || math.Abs(pieces[i][j].Lon-want[i][j].Lon) > 1e-7 {
+				t.Errorf("piece %d point %d = %v, want %v", i, j, pieces[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestClipLineEnteringTwice(t *testing.T) {
+	outline := testOutline(t, 2)
+	//leaves the zone through its north border and comes back in further east
+	line := lineOf(
+		Coordinates{Lat: 52.51, Lon: 13.375},
+		Coordinates{Lat: 52.51, Lon: 13.385},
+		Coordinates{Lat: 52.525, Lon: 13.385},
+		Coordinates{Lat: 52.525, Lon: 13.387},
+		Coordinates{Lat: 52.515, Lon: 13.387},
+		Coordinates{Lat: 52.515, Lon: 13.395},
+	)
+	assertPieces(t, clipLine(line, outline), [][]Coordinates{
+		{{Lat: 52.51, Lon: 13.38}, {Lat: 52.51, Lon: 13.385}, {Lat: 52.52, Lon: 13.385}},
+		{{Lat: 52.52, Lon: 13.387}, {Lat: 52.515, Lon: 13.387}, {Lat: 52.515, Lon: 13.39}},
+	})
+}
+
+func TestClipLineSkipsHole(t *testing.T) {
+	outline := testOutline(t, 1)
+	line := lineOf(Coordinates{Lat: 52.51, Lon: 13.395}, Coordinates{Lat: 52.51, Lon: 13.425})
+	assertPieces(t, clipLine(line, outline), [][]Coordinates{
+		{{Lat: 52.51, Lon: 13.40}, {Lat: 52.51, Lon: 13.405}},
+		{{Lat: 52.51, Lon: 13.415}, {Lat: 52.51, Lon: 13.42}},
+	})
+
+	inHole := lineOf(Coordinates{Lat: 52.508, Lon: 13.408}, Coordinates{Lat: 52.512, Lon: 13.412})
+	if pieces := clipLine(inHole, outline); len(pieces) != 0 {
+		t.Errorf("line within the hole clipped to %v, want nothing", pieces)
+	}
+}
+
+func TestClipLineVertexOnBorder(t *testing.T) {
+	outline := testOutline(t, 2)
+	//the second point lies on the west border, the third within the zone
+	line := lineOf(
+		Coordinates{Lat: 52.51, Lon: 13.37},
+		Coordinates{Lat: 52.51, Lon: 13.38},
+		Coordinates{Lat: 52.51, Lon: 13.385},
+		Coordinates{Lat: 52.53, Lon: 13.385},
+	)
+	assertPieces(t, clipLine(line, outline), [][]Coordinates{
+		{{Lat: 52.51, Lon: 13.38}, {Lat: 52.51, Lon: 13.385}, {Lat: 52.52, Lon: 13.385}},
+	})
+
+	//the border belongs to the zone like in postgis, a line along it is within
+	along := lineOf(Coordinates{Lat: 52.49, Lon: 13.38}, Coordinates{Lat: 52.53, Lon: 13.38})
+	assertPieces(t, clipLine(along, outline), [][]Coordinates{
+		{{Lat: 52.50, Lon: 13.38}, {Lat: 52.52, Lon: 13.38}},
+	})
+}
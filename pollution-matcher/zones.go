@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/paulmach/orb/encoding/wkb"
+)
+
+var (
+	dbMaxOpenConns    = 10
+	dbMaxIdleConns    = 5
+	dbConnMaxLifetime = 30 * time.Minute
+	dbQueryTimeout    = 5 * time.Second
+	dbConnectRetries  = 12 // postgis may still be starting, preparing is retried every 5 seconds
+)
+
+//intersectQuery builds the route line from the bound coordinate arrays and intersects it with every zone
+//it touches. The intersection is computed once per zone in the lateral join.
+const intersectQuery = `
+WITH route AS (
+	SELECT ST_SetSRID(ST_MakeLine(ARRAY(
+		SELECT ST_MakePoint(p.lon, p.lat)
+		FROM unnest($1::float8[], $2::float8[]) WITH ORDINALITY AS p(lon, lat, n)
+		ORDER BY p.n
+	)), 4326) AS line
+)
+SELECT z.id, ST_AsGeoJSON(i.geometry), z.pollution, ` + validityColumns + `, ` + scheduleColumn + `, ` + liveColumns + `
+FROM berlin_polygons z
+` + liveJoin + `
+CROSS JOIN route
+CROSS JOIN LATERAL (SELECT ST_Intersection(z.outline, route.line) AS geometry) i
+WHERE ST_Intersects(z.outline, route.line) AND NOT ST_IsEmpty(i.geometry)`
+
+const listZonesQuery = `SELECT z.id, z.name, z.pollution, ` + validityColumns + `, ST_AsBinary(z.outline), ` + scheduleColumn + `, ` + liveColumns + `
+FROM berlin_polygons z ` + liveJoin + ` ORDER BY z.id`
+
+const getZoneQuery = `SELECT z.id, z.name, z.pollution, ` + validityColumns + `, ST_AsBinary(z.outline), ` + scheduleColumn + `, ` + liveColumns + `
+FROM berlin_polygons z ` + liveJoin + ` WHERE z.id = $1`
+
+const validityColumns = `to_char(z.valid_from, 'YYYY-MM-DD'), to_char(z.valid_until, 'YYYY-MM-DD')`
+
+//liveJoin adds the level the sensors measured in zone z, the live columns are null without one
+const liveJoin = `LEFT JOIN zone_live_levels l ON l.zone_id = z.id`
+
+const liveColumns = `l.pollution, l.window_end`
+
+//scheduleColumn selects the level rules of zone z as json list, the first rule in force wins
+const scheduleColumn = `(
+	SELECT json_agg(json_build_object(
+		'pollution', s.pollution,
+		'days', s.days,
+		'from', to_char(s.start_time, 'HH24:MI'),
+		'to', to_char(s.end_time, 'HH24:MI'),
+		'validFrom', to_char(s.valid_from, 'YYYY-MM-DD'),
+		'validUntil', to_char(s.valid_until, 'YYYY-MM-DD')
+	) ORDER BY s.priority DESC, s.id)
+	FROM zone_schedules s
+	WHERE s.zone_id = z.id
+)`
+
+//zoneRepository is the ZoneStore querying the pollution zones in postgis with prepared statements
+type zoneRepository struct {
+	db            *sql.DB
+	intersectStmt *sql.Stmt
+	listStmt      *sql.Stmt
+	getStmt       *sql.Stmt
+	timeout       time.Duration
+}
+
+//openZoneDB opens the connection pool to postgis
+func openZoneDB(connStr string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(dbMaxOpenConns)
+	db.SetMaxIdleConns(dbMaxIdleConns)
+	db.SetConnMaxLifetime(dbConnMaxLifetime)
+	return db, nil
+}
+
+//newZoneRepository prepares the statements of the repository, it waits for the database to come up and
+//schema/zones.sql to have created the tables
+func newZoneRepository(db *sql.DB, timeout time.Duration) (*zoneRepository, error) {
+	var err error
+	for attempt := 0; attempt <= dbConnectRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Println("---db not ready, retrying---")
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+		}
+		r := &zoneRepository{db: db, timeout: timeout}
+		if r.intersectStmt, err = db.Prepare(intersectQuery); err != nil {
+			continue
+		}
+		if r.listStmt, err = db.Prepare(listZonesQuery); err != nil {
+			r.intersectStmt.Close()
+			continue
+		}
+		if r.getStmt, err = db.Prepare(getZoneQuery); err != nil {
+			r.intersectStmt.Close()
+			r.listStmt.Close()
+			continue
+		}
+		return r, nil
+	}
+	return nil, err
+}
+
+//Intersect returns the parts of the route lying within pollution zones
+func (r *zoneRepository) Intersect(ctx context.Context, route []Coordinates) ([]ZoneIntersection, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	lons := make([]float64, 0, len(route))
+	lats := make([]float64, 0, len(route))
+	for _, point := range route {
+		lons = append(lons, point.Lon)
+		lats = append(lats, point.Lat)
+	}
+	rows, err := r.intersectStmt.QueryContext(ctx, pq.Array(lons), pq.Array(lats))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var intersections []ZoneIntersection
+	for rows.Next() {
+		var geoJSON string
+		var validFrom, validUntil sql.NullString
+		var schedule []byte
+		var livePollution sql.NullInt64
+		var liveMeasured pq.NullTime
+		var intersection ZoneIntersection
+		if err := rows.Scan(&intersection.ZoneID, &geoJSON, &intersection.Pollution, &validFrom, &validUntil, &schedule, &livePollution, &liveMeasured); err != nil {
+			return nil, err
+		}
+		intersection.Live = LiveLevel{Pollution: int(livePollution.Int64), MeasuredAt: liveMeasured.Time}
+		intersection.ValidFrom, intersection.ValidUntil = validFrom.String, validUntil.String
+		intersection.Schedule = parseSchedule(intersection.ZoneID, schedule)
+		intersection.Lines, err = parseLines(geoJSON)
+		if err != nil {
+			fmt.Println("---geojson parsing error in segment generation---")
+			fmt.Println(err)
+			continue
+		}
+		intersections = append(intersections, intersection)
+	}
+	return intersections, rows.Err()
+}
+
+//ListZones returns all pollution zones
+func (r *zoneRepository) ListZones(ctx context.Context) ([]Zone, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.listStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var zones []Zone
+	for rows.Next() {
+		z, err := scanZone(rows)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+//GetZone returns the zone with the id or ErrZoneNotFound
+func (r *zoneRepository) GetZone(ctx context.Context, id int64) (Zone, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	z, err := scanZone(r.getStmt.QueryRowContext(ctx, id))
+	if err == sql.ErrNoRows {
+		return z, ErrZoneNotFound
+	}
+	return z, err
+}
+
+//scanZone reads a zone selected as id, name, pollution, validity, outline as wkb, schedule and live level
+func scanZone(row interface {
+	Scan(dest ...interface{}) error
+}) (Zone, error) {
+	var z Zone
+	var name, validFrom, validUntil sql.NullString
+	var outline, schedule []byte
+	var livePollution sql.NullInt64
+	var liveMeasured pq.NullTime
+	if err := row.Scan(&z.ID, &name, &z.Pollution, &validFrom, &validUntil, &outline, &schedule, &livePollution, &liveMeasured); err != nil {
+		return z, err
+	}
+	z.Live = LiveLevel{Pollution: int(livePollution.Int64), MeasuredAt: liveMeasured.Time}
+	z.Name = name.String
+	z.ValidFrom, z.ValidUntil = validFrom.String, validUntil.String
+	z.Schedule = parseSchedule(z.ID, schedule)
+	geometry, err := wkb.Unmarshal(outline)
+	if err != nil {
+		return z, err
+	}
+	z.Outline, err = toMultiPolygon(geometry)
+	return z, err
+}
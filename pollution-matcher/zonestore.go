@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	nats "github.com/nats-io/go-nats"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+var (
+	zoneMode  = os.Getenv("ZONE_MODE")  // postgis queries each route, memory indexes the postgis zones in process, geojson indexes ZONES_FILE without a database
+	zonesFile = os.Getenv("ZONES_FILE") // geojson feature collection of the zones in geojson mode
+)
+
+//ErrZoneNotFound is returned by GetZone for unknown ids
+var ErrZoneNotFound = errors.New("zone not found")
+
+//Zone is a pollution zone with its outline in EPSG:4326
+type Zone struct {
+	ID         int64
+	Name       string
+	Pollution  int // level when no rule of the schedule is in force
+	Schedule   []LevelRule
+	Live       LiveLevel
+	ValidFrom  string // YYYY-MM-DD, the zone is ignored outside its validity
+	ValidUntil string // YYYY-MM-DD, inclusive
+	Outline    orb.MultiPolygon
+}
+
+//ZoneIntersection are the contiguous parts of a route within one pollution zone
+type ZoneIntersection struct {
+	ZoneID     int64
+	Lines      [][]Coordinates
+	Pollution  int
+	Schedule   []LevelRule
+	Live       LiveLevel
+	ValidFrom  string
+	ValidUntil string
+}
+
+//ZoneStore gives access to the pollution zones
+type ZoneStore interface {
+	Intersect(ctx context.Context, route []Coordinates) ([]ZoneIntersection, error)
+	ListZones(ctx context.Context) ([]Zone, error)
+	GetZone(ctx context.Context, id int64) (Zone, error)
+}
+
+//newZoneStore creates the zone store selected by ZONE_MODE, in memory stores are refreshed
+//periodically and on zones.changed events
+func newZoneStore(nc *nats.Conn) (ZoneStore, error) {
+	var load func(ctx context.Context) ([]Zone, error)
+	switch zoneMode {
+	case "geojson":
+		load = func(ctx context.Context) ([]Zone, error) {
+			return loadZonesFile(zonesFile)
+		}
+	case "", "postgis", "memory":
+		db, err := openZoneDB(connStr)
+		if err != nil {
+			return nil, err
+		}
+		repository, err := newZoneRepository(db, dbQueryTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if zoneMode != "memory" {
+			return repository, nil
+		}
+		load = repository.ListZones
+	default:
+		return nil, fmt.Errorf("unknown zone mode %q", zoneMode)
+	}
+
+	index := &zoneIndex{}
+	if err := index.refresh(load); err != nil {
+		return nil, err
+	}
+	go index.runRefresh(load, zonesRefreshInterval)
+	nc.Subscribe(zonesChangedQueueName, func(m *nats.Msg) {
+		fmt.Println("---zones changed, refreshing index---")
+		if err := index.refresh(load); err != nil {
+			fmt.Println("---zone refresh error---")
+			fmt.Println(err)
+		}
+	})
+	return index, nil
+}
+
+//loadZonesFile reads the zones from a geojson feature collection of polygons, each feature needs a
+//pollution property and may have a name, validFrom and validUntil dates and a schedule with a list of level rules. Features without
+//a numeric id are numbered in file order.
+func loadZonesFile(path string) ([]Zone, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := geojson.UnmarshalFeatureCollection(content)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make([]Zone, 0, len(collection.Features))
+	for i, feature := range collection.Features {
+		z := Zone{ID: int64(i + 1)}
+		switch id := feature.ID.(type) {
+		case float64:
+			z.ID = int64(id)
+		case string:
+			if parsed, err := strconv.ParseInt(id, 10, 64); err == nil {
+				z.ID = parsed
+			}
+		}
+		z.Name, _ = feature.Properties["name"].(string)
+		z.ValidFrom, _ = feature.Properties["validFrom"].(string)
+		z.ValidUntil, _ = feature.Properties["validUntil"].(string)
+		pollution, ok := feature.Properties["pollution"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("zone %d has no numeric pollution property", z.ID)
+		}
+		z.Pollution = int(pollution)
+		if schedule, ok := feature.Properties["schedule"]; ok {
+			encoded, err := json.Marshal(schedule)
+			if err != nil {
+				return nil, err
+			}
+			z.Schedule = parseSchedule(z.ID, encoded)
+		}
+		if z.Outline, err = toMultiPolygon(feature.Geometry); err != nil {
+			return nil, fmt.Errorf("zone %d: %v", z.ID, err)
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+//toMultiPolygon returns the polygonal geometry as multi polygon
+func toMultiPolygon(geometry orb.Geometry) (orb.MultiPolygon, error) {
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		return orb.MultiPolygon{g}, nil
+	case orb.MultiPolygon:
+		return g, nil
+	case nil:
+		return nil, errors.New("zone has no outline")
+	}
+	return nil, fmt.Errorf("zone outline is a %s, not a polygon", geometry.GeoJSONType())
+}
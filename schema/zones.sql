@@ -0,0 +1,59 @@
+-- Schema of the pollution zones, the only place it is defined. Postgis runs it when its database is
+-- created, it only adds what is missing and can be rerun against an existing database:
+--   psql "$PG_URI" -f schema/zones.sql
+
+-- the zones, written by zone-import and the api
+CREATE TABLE IF NOT EXISTS berlin_polygons (
+	id          serial PRIMARY KEY,
+	name        text,
+	pollution   integer NOT NULL,
+	valid_from  date,
+	valid_until date,
+	outline     geometry(MultiPolygon, 4326) NOT NULL
+);
+-- columns imports older than the api lack
+ALTER TABLE berlin_polygons
+	ADD COLUMN IF NOT EXISTS id serial,
+	ADD COLUMN IF NOT EXISTS name text,
+	ADD COLUMN IF NOT EXISTS valid_from date,
+	ADD COLUMN IF NOT EXISTS valid_until date;
+
+-- level rules of the zones read by pollution-matcher, the api deletes them with their zone
+CREATE TABLE IF NOT EXISTS zone_schedules (
+	id          serial PRIMARY KEY,
+	zone_id     integer NOT NULL,
+	pollution   integer NOT NULL CHECK (pollution BETWEEN 1 AND 9),
+	days        text[],
+	start_time  time,
+	end_time    time,
+	valid_from  date,
+	valid_until date,
+	priority    integer NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS zone_schedules_zone_id ON zone_schedules (zone_id);
+
+-- levels sensor-aggregator measured from the readings of the last window, kept apart from the static
+-- level the api and zone-import write. pollution-matcher prefers them over the schedules while they are fresh.
+CREATE TABLE IF NOT EXISTS zone_live_levels (
+	zone_id    integer PRIMARY KEY,
+	pollution  integer NOT NULL CHECK (pollution BETWEEN 1 AND 9),
+	pollutant  text NOT NULL,
+	mean_value double precision NOT NULL,
+	readings   integer NOT NULL,
+	window_end timestamptz NOT NULL
+);
+
+-- audit trail of the live levels, old_pollution is 0 for the first level measured in a zone
+CREATE TABLE IF NOT EXISTS zone_level_audit (
+	id            serial PRIMARY KEY,
+	zone_id       integer NOT NULL,
+	old_pollution integer NOT NULL,
+	new_pollution integer NOT NULL,
+	pollutant     text NOT NULL,
+	mean_value    double precision NOT NULL,
+	readings      integer NOT NULL,
+	window_end    timestamptz NOT NULL,
+	changed_at    timestamptz NOT NULL DEFAULT now(),
+	source        text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS zone_level_audit_zone_id ON zone_level_audit (zone_id, changed_at);
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+//indexTable holds per pollutant the upper bounds of the levels 1 to 8 in µg/m³, values above the last
+//bound are level 9. The defaults follow the bands of the UK Daily Air Quality Index with its two top bands joined.
+var indexTable = map[string][]float64{
+	"no2":  {67, 134, 200, 267, 334, 400, 467, 534},
+	"pm10": {16, 33, 50, 58, 66, 75, 83, 91},
+	"pm25": {11, 23, 35, 41, 47, 53, 58, 64},
+	"o3":   {33, 66, 100, 120, 140, 160, 187, 213},
+}
+
+//loadIndexTable replaces the index table with the json object of pollutant bounds in the file
+func loadIndexTable(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var table map[string][]float64
+	if err := json.Unmarshal(content, &table); err != nil {
+		return err
+	}
+	normalized := map[string][]float64{}
+	for pollutant, bounds := range table {
+		if len(bounds) != 8 {
+			return fmt.Errorf("pollutant %s needs 8 bounds, has %d", pollutant, len(bounds))
+		}
+		for i := 1; i < len(bounds); i++ {
+			if bounds[i] <= bounds[i-1] {
+				return fmt.Errorf("bounds of pollutant %s are not ascending", pollutant)
+			}
+		}
+		normalized[strings.ToLower(pollutant)] = bounds
+	}
+	indexTable = normalized
+	return nil
+}
+
+//levelOf returns the pollution level of the concentration, false for pollutants without bounds
+func levelOf(pollutant string, value float64) (int, bool) {
+	bounds, ok := indexTable[pollutant]
+	if !ok {
+		return 0, false
+	}
+	for i, bound := range bounds {
+		if value <= bound {
+			return i + 1, true
+		}
+	}
+	return 9, true
+}
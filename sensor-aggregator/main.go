@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	micro "github.com/micro/go-micro"
+	nats "github.com/nats-io/go-nats"
+)
+
+var (
+	natsURI               = os.Getenv("NATS_URI")
+	connStr               = os.Getenv("PG_URI")
+	indexTableFile        = os.Getenv("INDEX_TABLE_FILE") // json object of pollutant to the 8 upper bounds of the levels 1 to 8
+	subscribeQueueName    = "sensor.reading"
+	zonesChangedQueueName = "zones.changed"
+	globalNatsConn        *nats.Conn
+	window                = newSlidingWindow()
+	levels                *zoneLevels
+)
+
+//SensorReadingMessageData is the reading of one pollutant by an air quality sensor
+type SensorReadingMessageData struct {
+	SensorID  string  `json:"sensorId"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Pollutant string  `json:"pollutant"` // no2, pm10, pm25, o3 or another pollutant of the index table
+	Value     float64 `json:"value"`     // concentration in µg/m³
+	Timestamp string  `json:"timestamp"`
+}
+
+//SensorReadingMessage received on sensor.reading
+type SensorReadingMessage struct {
+	Data SensorReadingMessageData `json:"data"`
+}
+
+func (msg SensorReadingMessageData) toString() string {
+	return fmt.Sprintf("SensorID: %s, Position: %f/%f, Pollutant: %s, Value: %f, Timestamp: %s",
+		msg.SensorID, msg.Lat, msg.Lon, msg.Pollutant, msg.Value, msg.Timestamp)
+}
+
+//ZonesChangedMessageData tells caches of the zones to reload
+type ZonesChangedMessageData struct {
+	ZoneID    int64  `json:"zoneId"`
+	Change    string `json:"change"`
+	Timestamp string `json:"timestamp"`
+	Sender    string `json:"sender"`
+	Topic     string `json:"topic"`
+}
+
+//ZonesChangedMessage published on zones.changed
+type ZonesChangedMessage struct {
+	Data ZonesChangedMessageData `json:"data"`
+}
+
+func main() {
+
+	service := micro.NewService(
+		micro.Name("go-micro-sensoraggregator"),
+		micro.RegisterTTL(time.Second*30),
+		micro.RegisterInterval(time.Second*10),
+	)
+
+	// optionally setup command line usage
+	service.Init()
+	nc, err := nats.Connect(natsURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	globalNatsConn = nc
+
+	if size, err := time.ParseDuration(os.Getenv("SENSOR_WINDOW")); err == nil {
+		windowSize = size
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("DB_QUERY_TIMEOUT")); err == nil {
+		dbQueryTimeout = timeout
+	}
+	if indexTableFile != "" {
+		if err := loadIndexTable(indexTableFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	levels, err = newZoneLevels(connStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nc.Subscribe(subscribeQueueName, func(m *nats.Msg) {
+		fmt.Printf("---Received a message:---\n%s\n", string(m.Data))
+		var msg SensorReadingMessage
+		if err := json.Unmarshal(m.Data, &msg); err != nil {
+			fmt.Println("---error:---\n", err)
+			return
+		}
+		go processReading(msg.Data)
+	})
+
+	// Run server
+	if err := service.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//processReading adds the reading to the window of every zone containing it and updates the zones
+//whose aggregated level changed
+func processReading(msg SensorReadingMessageData) {
+	pollutant := strings.ToLower(msg.Pollutant)
+	if _, ok := levelOf(pollutant, msg.Value); !ok {
+		fmt.Println("---no index for pollutant, reading dropped---\n" + msg.toString())
+		return
+	}
+	//the windows end at the readings, a reading without its time cannot be placed in one
+	readAt, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		fmt.Println("---invalid timestamp, reading dropped---\n" + msg.toString())
+		return
+	}
+
+	zoneIDs, err := levels.containing(msg.Lat, msg.Lon)
+	if err != nil {
+		fmt.Println("---zone lookup error---")
+		fmt.Println(err)
+		return
+	}
+	for _, zoneID := range zoneIDs {
+		level := window.add(windowKey{ZoneID: zoneID, Pollutant: pollutant}, reading{Time: readAt, Value: msg.Value})
+		if level.Level == 0 {
+			continue
+		}
+		old, changed, err := levels.update(zoneID, level)
+		if err != nil {
+			fmt.Printf("---level update error of zone %d---\n", zoneID)
+			fmt.Println(err)
+			continue
+		}
+		if changed {
+			fmt.Printf("--- Zone %d level %d -> %d from %s mean %.1f of %d readings ---\n",
+				zoneID, old, level.Level, level.Pollutant, level.Mean, level.Count)
+			publishZonesChanged(zoneID)
+		}
+	}
+}
+
+func publishZonesChanged(zoneID int64) {
+	msg := ZonesChangedMessage{
+		Data: ZonesChangedMessageData{
+			ZoneID:    zoneID,
+			Change:    "level",
+			Timestamp: time.Now().Local().Format(time.RFC3339),
+			Sender:    "sensor-aggregator",
+			Topic:     zonesChangedQueueName,
+		},
+	}
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	globalNatsConn.Publish(zonesChangedQueueName, msgJSON)
+}
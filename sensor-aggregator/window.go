@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var windowSize = time.Hour
+
+type reading struct {
+	Time  time.Time
+	Value float64
+}
+
+//windowKey identifies the readings of one pollutant within one zone
+type windowKey struct {
+	ZoneID    int64
+	Pollutant string
+}
+
+//aggregate is the level of a zone from the pollutant with the worst mean within the window
+type aggregate struct {
+	Level     int
+	Pollutant string
+	Mean      float64
+	Count     int
+	End       time.Time // newest reading of the window
+}
+
+//slidingWindow keeps the readings of the last window size per zone and pollutant. Each window ends at the
+//newest reading of its zone and pollutant, so readings replayed from the past are aggregated like live ones
+//and a zone with late sensors does not lose its readings to the clock of another.
+type slidingWindow struct {
+	mutex    sync.Mutex
+	newest   map[windowKey]time.Time
+	readings map[windowKey][]reading
+}
+
+func newSlidingWindow() *slidingWindow {
+	return &slidingWindow{newest: map[windowKey]time.Time{}, readings: map[windowKey][]reading{}}
+}
+
+//add stores the reading and aggregates the readings of its zone within the window. Only pollutants of the
+//index table may be added. Pollutants of the zone whose newest reading is older than the window of the
+//added one are stale and no longer count.
+func (w *slidingWindow) add(key windowKey, r reading) aggregate {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if r.Time.After(w.newest[key]) {
+		w.newest[key] = r.Time
+	}
+	w.readings[key] = append(w.readings[key], r)
+	end := w.newest[key]
+
+	var pollutants []string
+	for k := range w.readings {
+		if k.ZoneID == key.ZoneID {
+			pollutants = append(pollutants, k.Pollutant)
+		}
+	}
+	sort.Strings(pollutants)
+
+	worst := aggregate{End: end}
+	for _, pollutant := range pollutants {
+		k := windowKey{ZoneID: key.ZoneID, Pollutant: pollutant}
+		newest := w.newest[k]
+		kept := w.readings[k][:0]
+		sum := 0.0
+		for _, existing := range w.readings[k] {
+			if newest.Sub(existing.Time) < windowSize {
+				kept = append(kept, existing)
+				sum += existing.Value
+			}
+		}
+		if end.Sub(newest) >= windowSize {
+			delete(w.readings, k)
+			delete(w.newest, k)
+			continue
+		}
+		w.readings[k] = kept
+
+		current := aggregate{Pollutant: pollutant, Mean: sum / float64(len(kept)), Count: len(kept), End: newest}
+		current.Level, _ = levelOf(pollutant, current.Mean)
+		if current.Level > worst.Level {
+			worst = current
+		}
+	}
+	return worst
+}
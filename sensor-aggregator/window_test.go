@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2026, 5, 4, 8, 0, 0, 0, time.UTC)
+
+func TestWindowEndsPerZone(t *testing.T) {
+	w := newSlidingWindow()
+	late := windowKey{ZoneID: 1, Pollutant: "no2"}
+	live := windowKey{ZoneID: 2, Pollutant: "no2"}
+
+	w.add(late, reading{Time: testStart, Value: 300})
+	//a reading of another zone hours later must not expire the readings of the late zone
+	w.add(live, reading{Time: testStart.Add(3 * time.Hour), Value: 50})
+	level := w.add(late, reading{Time: testStart.Add(10 * time.Minute), Value: 100})
+
+	if level.Count != 2 || level.Mean != 200 {
+		t.Errorf("late zone aggregated %d readings with mean %.1f, want 2 with mean 200", level.Count, level.Mean)
+	}
+	if !level.End.Equal(testStart.Add(10 * time.Minute)) {
+		t.Errorf("late zone window ends %s, want at its newest reading", level.End)
+	}
+}
+
+func TestWindowDropsOldReadings(t *testing.T) {
+	w := newSlidingWindow()
+	key := windowKey{ZoneID: 1, Pollutant: "no2"}
+
+	w.add(key, reading{Time: testStart, Value: 500})
+	level := w.add(key, reading{Time: testStart.Add(windowSize), Value: 100})
+	if level.Count != 1 || level.Mean != 100 {
+		t.Errorf("aggregated %d readings with mean %.1f, want only the newest", level.Count, level.Mean)
+	}
+}
+
+func TestWindowIgnoresStalePollutants(t *testing.T) {
+	w := newSlidingWindow()
+	no2 := windowKey{ZoneID: 1, Pollutant: "no2"}
+	pm10 := windowKey{ZoneID: 1, Pollutant: "pm10"}
+
+	w.add(no2, reading{Time: testStart, Value: 500})
+	//the no2 sensor stopped reporting, its last window no longer counts an hour later
+	level := w.add(pm10, reading{Time: testStart.Add(windowSize + time.Minute), Value: 20})
+	if level.Pollutant != "pm10" {
+		t.Errorf("zone level from %s, want pm10 after no2 went stale", level.Pollutant)
+	}
+}
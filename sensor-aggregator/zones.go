@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	dbQueryTimeout   = 5 * time.Second
+	dbConnectRetries = 12 // postgis may still be starting, preparing is retried every 5 seconds
+)
+
+const containingZonesQuery = `SELECT id FROM berlin_polygons WHERE ST_Contains(outline, ST_SetSRID(ST_MakePoint($1, $2), 4326)) ORDER BY id`
+
+//zoneLevels finds the zones of the readings and changes their levels in postgis
+type zoneLevels struct {
+	db           *sql.DB
+	containsStmt *sql.Stmt
+}
+
+//newZoneLevels prepares the statements, it waits for the database to come up and schema/zones.sql to have
+//created the tables
+func newZoneLevels(connStr string) (*zoneLevels, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt <= dbConnectRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Println("---db not ready, retrying---")
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+		}
+		z := &zoneLevels{db: db}
+		if z.containsStmt, err = db.Prepare(containingZonesQuery); err != nil {
+			continue
+		}
+		return z, nil
+	}
+	return nil, err
+}
+
+//containing returns the ids of the zones the position lies in
+func (z *zoneLevels) containing(lat float64, lon float64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	rows, err := z.containsStmt.QueryContext(ctx, lon, lat)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+//update sets the live level of the zone and records the change in the audit trail, it returns the old level
+//and false if the zone already had the level. The static level of the zone is left alone.
+func (z *zoneLevels) update(zoneID int64, level aggregate) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	tx, err := z.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	//locking the zone serializes the updates of a zone that has no live level yet
+	var live sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT l.pollution FROM berlin_polygons z LEFT JOIN zone_live_levels l ON l.zone_id = z.id
+		WHERE z.id = $1 FOR UPDATE OF z`, zoneID).Scan(&live)
+	if err != nil {
+		return 0, false, err
+	}
+	old := int(live.Int64)
+	_, err = tx.ExecContext(ctx, `INSERT INTO zone_live_levels (zone_id, pollution, pollutant, mean_value, readings, window_end)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (zone_id) DO UPDATE SET pollution = $2, pollutant = $3, mean_value = $4, readings = $5, window_end = $6`,
+		zoneID, level.Level, level.Pollutant, level.Mean, level.Count, level.End)
+	if err != nil {
+		return old, false, err
+	}
+	if old == level.Level {
+		//the window end moved on, the level is still fresh
+		return old, false, tx.Commit()
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO zone_level_audit (zone_id, old_pollution, new_pollution, pollutant, mean_value, readings, window_end, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'sensor')`,
+		zoneID, old, level.Level, level.Pollutant, level.Mean, level.Count, level.End)
+	if err != nil {
+		return old, false, err
+	}
+	return old, true, tx.Commit()
+}
@@ -30,6 +30,19 @@ var (
 		9: 9,
 	}
 	pricesPerCar = make(map[string]float64)
+	//lowConfidencePolicy decides about charges on doubtful matches
+	lowConfidencePolicy = policyFlag
+	//interpolatedPolicy decides about charges on routes interpolated through gaps like tunnels
+	interpolatedPolicy = policyCharge
+)
+
+//chargePolicy tells what happens to a charge that is based on a doubtful or interpolated route
+type chargePolicy string
+
+const (
+	policyCharge chargePolicy = "charge"
+	policyFlag   chargePolicy = "flag"
+	policyRefuse chargePolicy = "refuse"
 )
 
 //Coordinates Struct to unite a Latitude and Longitude to one location
@@ -40,19 +53,28 @@ type Coordinates struct {
 
 //Segment is a polluted area and defined by a polygon between segment sections
 type Segment struct {
-	SegmentID       int           `json:"segmentId"`
+	SegmentID       string        `json:"segmentId"`
+	ZoneID          int64         `json:"zoneId"`
+	Ordinal         int           `json:"ordinal"`
+	Offset          float64       `json:"offset"`
+	EnteredAt       string        `json:"enteredAt"`
 	PollutionLevel  int           `json:"pollutionLevel"`
+	Interpolated    bool          `json:"interpolated"`
 	SegmentSections []Coordinates `json:"segmentSections"`
 }
 
 //PollutionMatcherMessage Data the pollution matcher is sending after processing
 type PollutionMatcherMessage struct {
-	MessageID int       `json:"messageId"`
-	CarID     string    `json:"carId"`
-	Timestamp string    `json:"timestamp"`
-	Segments  []Segment `json:"segments"`
-	Sender    string    `json:"sender"`
-	Topic     string    `json:"topic"`
+	MessageID     int       `json:"messageId"`
+	CarID         string    `json:"carId"`
+	TripID        string    `json:"tripId"`
+	Timestamp     string    `json:"timestamp"`
+	Segments      []Segment `json:"segments"`
+	Confidence    float64   `json:"confidence"`
+	LowConfidence bool      `json:"lowConfidence"`
+	Interpolated  bool      `json:"interpolated"`
+	Sender        string    `json:"sender"`
+	Topic         string    `json:"topic"`
 }
 
 func (m PollutionMatcherMessage) toString() string {
@@ -80,12 +102,18 @@ type LogMessageData struct {
 
 //TollCalculatorMessage sent out
 type TollCalculatorMessage struct {
-	MessageID int     `json:"messageId"`
-	CarID     string  `json:"carId"`
-	Timestamp string  `json:"timestamp"`
-	Toll      float64 `json:"toll"`
-	Sender    string  `json:"sender"`
-	Topic     string  `json:"topic"`
+	MessageID       int      `json:"messageId"`
+	CarID           string   `json:"carId"`
+	TripID          string   `json:"tripId"`
+	Timestamp       string   `json:"timestamp"`
+	Toll            float64  `json:"toll"`
+	Confidence      float64  `json:"confidence"`
+	Interpolated    bool     `json:"interpolated"`
+	Flagged         bool     `json:"flagged"`                   // the charge is based on a doubtful match or an interpolated route
+	Refused         bool     `json:"refused"`                   // the doubtful match was not charged
+	RefusedSegments []string `json:"refusedSegments,omitempty"` // ids of the segments on interpolated legs that were not charged
+	Sender          string   `json:"sender"`
+	Topic           string   `json:"topic"`
 }
 
 func (m TollCalculatorMessage) toString() string {
@@ -102,6 +130,14 @@ func (m TollCalculatorOutput) toString() string {
 }
 
 func main() {
+	//a typo in a policy would silently charge or refuse the wrong trips, so unknown values stop the service
+	var err error
+	if lowConfidencePolicy, err = parsePolicy("LOW_CONFIDENCE_POLICY", lowConfidencePolicy); err != nil {
+		log.Fatal(err)
+	}
+	if interpolatedPolicy, err = parsePolicy("INTERPOLATED_POLICY", interpolatedPolicy); err != nil {
+		log.Fatal(err)
+	}
 
 	service := micro.NewService(
 		micro.Name("go.micro.tollcalculator"),
@@ -168,29 +204,66 @@ func processMessage(msg PollutionMatcherMessage) {
 	fmt.Printf("-------------------- PM MESSAGE!!! ----------\n\n")
 	fmt.Printf("%s\n\n", msg.toString())
 
+	msgData := TollCalculatorMessage{
+		Sender:     "GoMicro-TollCalculator",
+		Topic:      "toll.calculated",
+		MessageID:  msg.MessageID,
+		CarID:      msg.CarID,
+		TripID:     msg.TripID,
+		Timestamp:  time.Now().Local().Format(time.RFC3339),
+		Confidence: msg.Confidence,
+	}
+
+	if msg.LowConfidence {
+		msgData = applyPolicy(lowConfidencePolicy, msgData)
+	}
 	priceListSum := 0.0
 	for _, seg := range msg.Segments {
-		var sections = seg.SegmentSections
-		distance := Distance(sections[0].Lat, sections[0].Lon, sections[1].Lat, sections[1].Lon)
-		priceListSum += distance * float64(priceList[seg.PollutionLevel]) / 10
-
+		price := sectionsDistance(seg.SegmentSections) * float64(priceList[seg.PollutionLevel]) / 10
+		if seg.Interpolated {
+			//zones crossed underground are charged like any other unless the policy says otherwise,
+			//a refusal only drops the segments on interpolated legs
+			msgData.Interpolated = true
+			if interpolatedPolicy == policyRefuse {
+				msgData.RefusedSegments = append(msgData.RefusedSegments, seg.SegmentID)
+				continue
+			}
+			msgData = applyPolicy(interpolatedPolicy, msgData)
+		}
+		priceListSum += price
 	}
-
-	pricesPerCar[msg.CarID] += priceListSum
-
-	msgData := TollCalculatorMessage{
-		Sender:    "GoMicro-TollCalculator",
-		Topic:     "toll.calculated",
-		MessageID: msg.MessageID,
-		CarID:     msg.CarID,
-		Timestamp: time.Now().Local().Format(time.RFC3339),
-		Toll:      pricesPerCar[msg.CarID],
+	if !msgData.Refused {
+		pricesPerCar[msg.CarID] += priceListSum
 	}
+	msgData.Toll = pricesPerCar[msg.CarID]
 
 	publishTollCalculatorMessage(msgData)
 
 }
 
+//parsePolicy reads the policy from the environment variable, fallback is used if it is not set
+func parsePolicy(name string, fallback chargePolicy) (chargePolicy, error) {
+	value := os.Getenv(name)
+	switch policy := chargePolicy(value); policy {
+	case "":
+		return fallback, nil
+	case policyCharge, policyFlag, policyRefuse:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%s: unknown policy %q, use charge, flag or refuse", name, value)
+}
+
+//applyPolicy returns the message with its charge flagged or refused as the policy says
+func applyPolicy(policy chargePolicy, msg TollCalculatorMessage) TollCalculatorMessage {
+	switch policy {
+	case policyFlag:
+		msg.Flagged = true
+	case policyRefuse:
+		msg.Refused = true
+	}
+	return msg
+}
+
 func publishTollCalculatorMessage(msg TollCalculatorMessage) {
 	outputMsg := TollCalculatorOutput{
 		Data: msg,
@@ -207,6 +280,15 @@ func publishTollCalculatorMessage(msg TollCalculatorMessage) {
 	fmt.Println("--- Publishing process completed ---")
 }
 
+//sectionsDistance returns the length in meters of the road path along all segment sections
+func sectionsDistance(sections []Coordinates) float64 {
+	distance := 0.0
+	for i := 1; i < len(sections); i++ {
+		distance += Distance(sections[i-1].Lat, sections[i-1].Lon, sections[i].Lat, sections[i].Lon)
+	}
+	return distance
+}
+
 //Distance returns haversine distance in meters
 func Distance(lat1, lon1, lat2, lon2 float64) float64 {
 	// convert to radians
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+//geoJSONCRS is the crs member of GeoJSON from before RFC 7946, which dropped it for EPSG:4326 only
+type geoJSONCRS struct {
+	CRS struct {
+		Properties struct {
+			Name string `json:"name"`
+		} `json:"properties"`
+	} `json:"crs"`
+}
+
+//readGeoJSON reads the polygon features of a feature collection, properties are formatted as strings
+func readGeoJSON(path string) ([]sourceZone, int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection, err := geojson.UnmarshalFeatureCollection(content)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	srid := 4326
+	var crs geoJSONCRS
+	if err := json.Unmarshal(content, &crs); err == nil && crs.CRS.Properties.Name != "" {
+		var ok bool
+		if srid, ok = sridFromCRSName(crs.CRS.Properties.Name); !ok {
+			return nil, 0, fmt.Errorf("unknown crs %q, set SOURCE_SRID", crs.CRS.Properties.Name)
+		}
+	}
+
+	var zones []sourceZone
+	for i, feature := range collection.Features {
+		zone := sourceZone{Attributes: map[string]string{}}
+		for key, value := range feature.Properties {
+			if value != nil {
+				zone.Attributes[key] = fmt.Sprint(value)
+			}
+		}
+		switch g := feature.Geometry.(type) {
+		case orb.Polygon:
+			zone.Outline = orb.MultiPolygon{g}
+		case orb.MultiPolygon:
+			zone.Outline = g
+		case nil:
+			return nil, 0, fmt.Errorf("feature %d has no geometry", i+1)
+		default:
+			fmt.Printf("---skipping feature %d, a %s is no zone---\n", i+1, g.GeoJSONType())
+			continue
+		}
+		zones = append(zones, zone)
+	}
+	return zones, srid, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+type kmlPlacemark struct {
+	Name          string       `xml:"name"`
+	Data          []kmlData    `xml:"ExtendedData>Data"`
+	SchemaData    []kmlData    `xml:"ExtendedData>SchemaData>SimpleData"`
+	Polygons      []kmlPolygon `xml:"Polygon"`
+	MultiPolygons []kmlPolygon `xml:"MultiGeometry>Polygon"`
+}
+
+//kmlData is an extended data value, given as value element in Data and as text in SimpleData
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+	Text  string `xml:",chardata"`
+}
+
+type kmlPolygon struct {
+	Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+}
+
+//readKML reads the polygon placemarks of the kml file wherever they are nested, the placemark name
+//becomes the name attribute. KML coordinates are always EPSG:4326.
+func readKML(path string) ([]sourceZone, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var zones []sourceZone
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var placemark kmlPlacemark
+		if err := decoder.DecodeElement(&placemark, &start); err != nil {
+			return nil, 0, err
+		}
+
+		zone := sourceZone{Attributes: map[string]string{}}
+		if placemark.Name != "" {
+			zone.Attributes["name"] = strings.TrimSpace(placemark.Name)
+		}
+		for _, data := range placemark.Data {
+			zone.Attributes[data.Name] = strings.TrimSpace(data.Value)
+		}
+		for _, data := range placemark.SchemaData {
+			zone.Attributes[data.Name] = strings.TrimSpace(data.Text)
+		}
+		for _, p := range append(placemark.Polygons, placemark.MultiPolygons...) {
+			outer, err := parseKMLCoordinates(p.Outer)
+			if err != nil {
+				return nil, 0, fmt.Errorf("placemark %q: %v", placemark.Name, err)
+			}
+			polygon := orb.Polygon{outer}
+			for _, coordinates := range p.Inner {
+				inner, err := parseKMLCoordinates(coordinates)
+				if err != nil {
+					return nil, 0, fmt.Errorf("placemark %q: %v", placemark.Name, err)
+				}
+				polygon = append(polygon, inner)
+			}
+			zone.Outline = append(zone.Outline, polygon)
+		}
+		// placemarks of points and lines are no zones
+		if len(zone.Outline) > 0 {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, 4326, nil
+}
+
+//parseKMLCoordinates parses the whitespace separated lon,lat[,alt] tuples of a ring
+func parseKMLCoordinates(coordinates string) (orb.Ring, error) {
+	var ring orb.Ring
+	for _, tuple := range strings.Fields(coordinates) {
+		values := strings.Split(tuple, ",")
+		if len(values) < 2 {
+			return nil, fmt.Errorf("invalid coordinate %q", tuple)
+		}
+		lon, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		lat, err := strconv.ParseFloat(values[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, orb.Point{lon, lat})
+	}
+	return ring, nil
+}
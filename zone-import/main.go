@@ -0,0 +1,351 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	nats "github.com/nats-io/go-nats"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+)
+
+var (
+	natsURI               = os.Getenv("NATS_URI") // optional, zones.changed is published after the import
+	connStr               = os.Getenv("PG_URI")   // optional in dry run mode
+	zonesFiles            = os.Getenv("ZONES_FILE")
+	zonesTable            = "berlin_polygons"
+	levelAttribute        = "pollution"
+	levelMapping          = map[string]int{}
+	nameAttribute         = "name"
+	sourceSRID            = 0 // overrides the projection read from the files
+	importMode            = "insert"
+	dryRun                = false
+	dbConnectRetries      = 12 // postgis may still be starting, connecting is retried every 5 seconds
+	zonesChangedQueueName = "zones.changed"
+)
+
+//sourceZone is a zone as read from a file, before its level is mapped
+type sourceZone struct {
+	Attributes map[string]string
+	Outline    orb.MultiPolygon
+}
+
+//importZone is a zone ready to be written
+type importZone struct {
+	Name      string
+	Pollution int
+	Outline   orb.MultiPolygon
+	SRID      int
+}
+
+//importResult counts what happened to the zones of an import
+type importResult struct {
+	Read     int
+	Invalid  int
+	Inserted int
+	Updated  int
+}
+
+func (r importResult) toString() string {
+	return fmt.Sprintf("read: %d, invalid: %d, inserted: %d, updated: %d", r.Read, r.Invalid, r.Inserted, r.Updated)
+}
+
+//ZonesChangedMessageData tells caches of the zones to reload
+type ZonesChangedMessageData struct {
+	ZoneID    int64  `json:"zoneId"`
+	Change    string `json:"change"`
+	Timestamp string `json:"timestamp"`
+	Sender    string `json:"sender"`
+	Topic     string `json:"topic"`
+}
+
+//ZonesChangedMessage published on zones.changed
+type ZonesChangedMessage struct {
+	Data ZonesChangedMessageData `json:"data"`
+}
+
+func main() {
+	if table := os.Getenv("ZONES_TABLE"); table != "" {
+		zonesTable = table
+	}
+	if attribute := os.Getenv("LEVEL_ATTRIBUTE"); attribute != "" {
+		levelAttribute = attribute
+	}
+	if attribute := os.Getenv("NAME_ATTRIBUTE"); attribute != "" {
+		nameAttribute = attribute
+	}
+	if mapping := os.Getenv("LEVEL_MAPPING"); mapping != "" {
+		parsed, err := parseLevelMapping(mapping)
+		if err != nil {
+			log.Fatal(err)
+		}
+		levelMapping = parsed
+	}
+	if srid, err := strconv.Atoi(os.Getenv("SOURCE_SRID")); err == nil {
+		sourceSRID = srid
+	}
+	if mode := os.Getenv("IMPORT_MODE"); mode != "" {
+		if mode != "insert" && mode != "upsert" {
+			log.Fatalf("unknown import mode %q, use insert or upsert", mode)
+		}
+		importMode = mode
+	}
+	if parsed, err := strconv.ParseBool(os.Getenv("DRY_RUN")); err == nil {
+		dryRun = parsed
+	}
+	if zonesFiles == "" {
+		log.Fatal("ZONES_FILE is not set")
+	}
+
+	var zones []importZone
+	result := importResult{}
+	for _, path := range strings.Split(zonesFiles, ",") {
+		path = strings.TrimSpace(path)
+		read, srid, err := readZones(path)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if sourceSRID != 0 {
+			srid = sourceSRID
+		}
+		if srid == 0 {
+			log.Fatalf("%s: unknown projection, set SOURCE_SRID", path)
+		}
+		fmt.Printf("--- Read %d zones from %s in EPSG:%d ---\n", len(read), path, srid)
+		result.Read += len(read)
+		for i, source := range read {
+			zone, err := toImportZone(source, srid)
+			if err != nil {
+				fmt.Printf("---invalid zone %d of %s---\n", i+1, path)
+				fmt.Println(err)
+				result.Invalid++
+				continue
+			}
+			zones = append(zones, zone)
+		}
+	}
+
+	if connStr == "" {
+		if !dryRun {
+			log.Fatal("PG_URI is not set")
+		}
+		fmt.Println("--- Dry run without database, geometries were not validated by postgis ---")
+		fmt.Println("--- Import result: " + result.toString() + " ---")
+		return
+	}
+
+	db, err := connect(connStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err := importZones(db, zones, &result); err != nil {
+		log.Fatal(err)
+	}
+	if dryRun {
+		fmt.Println("--- Dry run, nothing was written ---")
+	}
+	fmt.Println("--- Import result: " + result.toString() + " ---")
+
+	if !dryRun && natsURI != "" && result.Inserted+result.Updated > 0 {
+		nc, err := nats.Connect(natsURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer nc.Close()
+		publishZonesChanged(nc)
+	}
+}
+
+//readZones reads a GeoJSON, Shapefile or KML file by its extension and returns the projection of its coordinates
+func readZones(path string) ([]sourceZone, int, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".geojson", ".json":
+		return readGeoJSON(path)
+	case ".shp":
+		return readShapefile(path)
+	case ".kml":
+		return readKML(path)
+	}
+	return nil, 0, fmt.Errorf("unknown file type %q, use .geojson, .shp or .kml", filepath.Ext(path))
+}
+
+//parseLevelMapping parses attribute values to levels given as value=level pairs separated by commas
+func parseLevelMapping(mapping string) (map[string]int, error) {
+	levels := map[string]int{}
+	for _, pair := range strings.Split(mapping, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid level mapping %q, use value=level", pair)
+		}
+		level, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || level < 1 || level > 9 {
+			return nil, fmt.Errorf("invalid level in mapping %q, use 1 to 9", pair)
+		}
+		levels[strings.TrimSpace(parts[0])] = level
+	}
+	return levels, nil
+}
+
+//toImportZone maps the level attribute and checks the rings, unclosed rings are closed
+func toImportZone(source sourceZone, srid int) (importZone, error) {
+	zone := importZone{Name: source.Attributes[nameAttribute], SRID: srid}
+	if importMode == "upsert" && zone.Name == "" {
+		return zone, fmt.Errorf("zone has no %s attribute to upsert by", nameAttribute)
+	}
+
+	value, ok := source.Attributes[levelAttribute]
+	if !ok {
+		return zone, fmt.Errorf("zone %q has no %s attribute", zone.Name, levelAttribute)
+	}
+	if level, ok := levelMapping[value]; ok {
+		zone.Pollution = level
+	} else if level, err := strconv.ParseFloat(value, 64); err == nil && level == float64(int(level)) {
+		zone.Pollution = int(level)
+	}
+	if zone.Pollution < 1 || zone.Pollution > 9 {
+		return zone, fmt.Errorf("zone %q: %s %q is no level between 1 and 9", zone.Name, levelAttribute, value)
+	}
+
+	if len(source.Outline) == 0 {
+		return zone, fmt.Errorf("zone %q has no outline", zone.Name)
+	}
+	for _, polygon := range source.Outline {
+		closed := make(orb.Polygon, 0, len(polygon))
+		for _, ring := range polygon {
+			if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+				ring = append(ring, ring[0])
+			}
+			if len(ring) < 4 {
+				return zone, fmt.Errorf("zone %q has a ring of less than 3 points", zone.Name)
+			}
+			closed = append(closed, ring)
+		}
+		zone.Outline = append(zone.Outline, closed)
+	}
+	return zone, nil
+}
+
+//connect opens the database, it waits for it to come up
+func connect(connStr string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt <= dbConnectRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Println("---db not ready, retrying---")
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+		}
+		if err = db.Ping(); err == nil {
+			return db, nil
+		}
+	}
+	return nil, err
+}
+
+//quoteTable quotes a table name that may be qualified with its schema
+func quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+//importZones writes the zones in one transaction, in dry run mode it is rolled back after every zone was
+//validated and written. Zones postgis finds invalid after reprojection are skipped. The table is created by
+//schema/zones.sql, another ZONES_TABLE needs its columns.
+func importZones(db *sql.DB, zones []importZone, result *importResult) error {
+	table := quoteTable(zonesTable)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//the outline parameter reprojected to EPSG:4326
+	const outline = `ST_Multi(ST_Transform(ST_SetSRID(ST_GeomFromWKB($1), $2::integer), 4326))`
+	validate, err := tx.Prepare(`SELECT ST_IsValidReason(` + outline + `)`)
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO ` + table + ` (outline, name, pollution) VALUES (` + outline + `, NULLIF($3, ''), $4)`)
+	if err != nil {
+		return err
+	}
+	update, err := tx.Prepare(`UPDATE ` + table + ` SET outline = ` + outline + `, pollution = $4 WHERE name = $3`)
+	if err != nil {
+		return err
+	}
+
+	for _, zone := range zones {
+		geometry, err := wkb.Marshal(zone.Outline)
+		if err != nil {
+			return err
+		}
+		var validity string
+		if err := validate.QueryRow(geometry, zone.SRID).Scan(&validity); err != nil {
+			return err
+		}
+		if validity != "Valid Geometry" {
+			fmt.Printf("---invalid zone %q---\n", zone.Name)
+			fmt.Println(validity)
+			result.Invalid++
+			continue
+		}
+
+		if importMode == "upsert" {
+			updated, err := update.Exec(geometry, zone.SRID, zone.Name, zone.Pollution)
+			if err != nil {
+				return err
+			}
+			if rows, err := updated.RowsAffected(); err != nil {
+				return err
+			} else if rows > 0 {
+				result.Updated++
+				continue
+			}
+		}
+		if _, err := insert.Exec(geometry, zone.SRID, zone.Name, zone.Pollution); err != nil {
+			return err
+		}
+		result.Inserted++
+	}
+
+	if dryRun {
+		return nil
+	}
+	if result.Invalid > 0 && result.Inserted+result.Updated == 0 {
+		return errors.New("no valid zones to import")
+	}
+	return tx.Commit()
+}
+
+func publishZonesChanged(nc *nats.Conn) {
+	msg := ZonesChangedMessage{
+		Data: ZonesChangedMessageData{
+			Change:    "imported",
+			Timestamp: time.Now().Local().Format(time.RFC3339),
+			Sender:    "zone-import",
+			Topic:     zonesChangedQueueName,
+		},
+	}
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nc.Publish(zonesChangedQueueName, msgJSON)
+	nc.Flush()
+	fmt.Println("--- Published zones.changed ---")
+}
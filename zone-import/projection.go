@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//esriProjections are the srids of projections commonly written to .prj files without an authority
+var esriProjections = map[string]int{
+	"GCS_WGS_1984":                             4326,
+	"WGS_1984_Web_Mercator_Auxiliary_Sphere":   3857,
+	"ETRS_1989_UTM_Zone_32N":                   25832,
+	"ETRS_1989_UTM_Zone_33N":                   25833,
+	"DHDN_3_Degree_Gauss_Zone_4":               31468,
+	"Deutsches_Hauptdreiecksnetz_Gauss_Zone_4": 31468,
+}
+
+var (
+	authorityPattern = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?(\d+)"?\]\s*\]\s*$`)
+	wktNamePattern   = regexp.MustCompile(`^\s*(?:PROJCS|GEOGCS)\["([^"]+)"`)
+)
+
+//sridFromPrj returns the srid of the well known text of a .prj file, from the authority of the outermost
+//coordinate system or its name
+func sridFromPrj(wkt string) (int, bool) {
+	if match := authorityPattern.FindStringSubmatch(wkt); match != nil {
+		srid, err := strconv.Atoi(match[1])
+		return srid, err == nil
+	}
+	if match := wktNamePattern.FindStringSubmatch(wkt); match != nil {
+		srid, ok := esriProjections[match[1]]
+		return srid, ok
+	}
+	return 0, false
+}
+
+//sridFromCRSName reads names like EPSG:25833, urn:ogc:def:crs:EPSG::25833 and urn:ogc:def:crs:OGC:1.3:CRS84
+func sridFromCRSName(name string) (int, bool) {
+	if strings.HasSuffix(name, "CRS84") {
+		return 4326, true
+	}
+	if !strings.Contains(name, "EPSG") {
+		return 0, false
+	}
+	srid, err := strconv.Atoi(name[strings.LastIndex(name, ":")+1:])
+	return srid, err == nil
+}
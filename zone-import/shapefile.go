@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+//shape types of the polygon records, the Z and M variants carry extra values after the points
+var polygonShapeTypes = map[int32]bool{5: true, 15: true, 25: true}
+
+//readShapefile reads the polygons of the .shp file with the attributes of the .dbf file next to it.
+//The source projection is taken from the .prj file, 0 if there is none.
+func readShapefile(path string) ([]sourceZone, int, error) {
+	base := strings.TrimSuffix(path, ".shp")
+	shp, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	dbf, err := ioutil.ReadFile(base + ".dbf")
+	if err != nil {
+		return nil, 0, err
+	}
+	attributes, err := readDBF(dbf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s.dbf: %v", base, err)
+	}
+
+	srid := 0
+	if prj, err := ioutil.ReadFile(base + ".prj"); err == nil {
+		var ok bool
+		if srid, ok = sridFromPrj(string(prj)); !ok {
+			fmt.Printf("---unknown projection in %s.prj, set SOURCE_SRID---\n", base)
+		}
+	}
+
+	if len(shp) < 100 || binary.BigEndian.Uint32(shp[0:4]) != 9994 {
+		return nil, 0, errors.New("not a shapefile")
+	}
+	var zones []sourceZone
+	for offset, record := 100, 0; offset+8 <= len(shp); record++ {
+		length := int(binary.BigEndian.Uint32(shp[offset+4:offset+8])) * 2
+		content := shp[offset+8:]
+		if length > len(content) {
+			return nil, 0, fmt.Errorf("record %d is truncated", record+1)
+		}
+		if length < 4 {
+			return nil, 0, fmt.Errorf("record %d has no shape type", record+1)
+		}
+		content = content[:length]
+		offset += 8 + length
+
+		zone := sourceZone{Attributes: map[string]string{}}
+		if record < len(attributes) {
+			zone.Attributes = attributes[record]
+		}
+		shapeType := int32(binary.LittleEndian.Uint32(content[0:4]))
+		if shapeType == 0 {
+			continue
+		}
+		if !polygonShapeTypes[shapeType] {
+			return nil, 0, fmt.Errorf("record %d has shape type %d, not a polygon", record+1, shapeType)
+		}
+		if zone.Outline, err = readShapePolygon(content); err != nil {
+			return nil, 0, fmt.Errorf("record %d: %v", record+1, err)
+		}
+		zones = append(zones, zone)
+	}
+	return zones, srid, nil
+}
+
+//readShapePolygon reads the rings of a polygon record. Outer rings run clockwise, holes counterclockwise
+//and belong to the outer ring they lie in.
+func readShapePolygon(content []byte) (orb.MultiPolygon, error) {
+	if len(content) < 44 {
+		return nil, errors.New("polygon record is truncated")
+	}
+	numParts := int(binary.LittleEndian.Uint32(content[36:40]))
+	numPoints := int(binary.LittleEndian.Uint32(content[40:44]))
+	pointsStart := 44 + 4*numParts
+	if len(content) < pointsStart+16*numPoints {
+		return nil, errors.New("polygon record is truncated")
+	}
+
+	var outers orb.MultiPolygon
+	var holes []orb.Ring
+	for part := 0; part < numParts; part++ {
+		start := int(binary.LittleEndian.Uint32(content[44+4*part:]))
+		end := numPoints
+		if part < numParts-1 {
+			end = int(binary.LittleEndian.Uint32(content[48+4*part:]))
+		}
+		if start < 0 || end > numPoints || start >= end {
+			return nil, errors.New("polygon part out of range")
+		}
+		ring := make(orb.Ring, 0, end-start)
+		for i := start; i < end; i++ {
+			point := content[pointsStart+16*i:]
+			ring = append(ring, orb.Point{
+				math.Float64frombits(binary.LittleEndian.Uint64(point[0:8])),
+				math.Float64frombits(binary.LittleEndian.Uint64(point[8:16])),
+			})
+		}
+		if signedArea(ring) < 0 {
+			outers = append(outers, orb.Polygon{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+
+	//files written without regard to the ring order only have counterclockwise rings
+	if len(outers) == 0 {
+		for _, ring := range holes {
+			outers = append(outers, orb.Polygon{ring})
+		}
+		return outers, nil
+	}
+	for _, hole := range holes {
+		owner := len(outers) - 1
+		for i, polygon := range outers {
+			if planar.RingContains(polygon[0], hole[0]) {
+				owner = i
+				break
+			}
+		}
+		outers[owner] = append(outers[owner], hole)
+	}
+	return outers, nil
+}
+
+//signedArea is positive for counterclockwise rings
+func signedArea(ring orb.Ring) float64 {
+	area := 0.0
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		area += a[0]*b[1] - b[0]*a[1]
+	}
+	return area / 2
+}
+
+//readDBF returns the attributes of every record of the dbase table, values are trimmed
+func readDBF(dbf []byte) ([]map[string]string, error) {
+	if len(dbf) < 32 {
+		return nil, errors.New("dbase header is truncated")
+	}
+	numRecords := int(binary.LittleEndian.Uint32(dbf[4:8]))
+	headerLength := int(binary.LittleEndian.Uint16(dbf[8:10]))
+	recordLength := int(binary.LittleEndian.Uint16(dbf[10:12]))
+	if headerLength > len(dbf) {
+		return nil, errors.New("dbase header is truncated")
+	}
+
+	type field struct {
+		name   string
+		length int
+	}
+	var fields []field
+	// the first byte of a record is the deletion flag
+	fieldsLength := 1
+	for offset := 32; offset+32 <= headerLength && dbf[offset] != 0x0D; offset += 32 {
+		name := dbf[offset : offset+11]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		fields = append(fields, field{name: string(name), length: int(dbf[offset+16])})
+		fieldsLength += int(dbf[offset+16])
+	}
+	if fieldsLength > recordLength {
+		return nil, fmt.Errorf("fields of %d bytes do not fit records of %d bytes", fieldsLength, recordLength)
+	}
+
+	records := make([]map[string]string, 0, numRecords)
+	for record := 0; record < numRecords; record++ {
+		offset := headerLength + record*recordLength
+		if offset+recordLength > len(dbf) {
+			return nil, fmt.Errorf("record %d is truncated", record+1)
+		}
+		position := offset + 1
+		attributes := map[string]string{}
+		for _, f := range fields {
+			attributes[f.name] = decodeDBFValue(dbf[position : position+f.length])
+			position += f.length
+		}
+		records = append(records, attributes)
+	}
+	return records, nil
+}
+
+//decodeDBFValue trims the value, values that are not utf-8 are read as latin-1 like most older files are written
+func decodeDBFValue(value []byte) string {
+	value = bytes.Trim(value, " \x00")
+	if utf8.Valid(value) {
+		return string(value)
+	}
+	runes := make([]rune, 0, len(value))
+	for _, b := range value {
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
+}
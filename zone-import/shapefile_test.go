@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+//clockwise rings are outer rings in shapefiles, counterclockwise ones holes
+var (
+	outerSquare = orb.Ring{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	holeSquare  = orb.Ring{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}}
+	eastSquare  = orb.Ring{{20, 0}, {20, 10}, {30, 10}, {30, 0}, {20, 0}}
+	eastHole    = orb.Ring{{22, 2}, {24, 2}, {24, 4}, {22, 4}, {22, 2}}
+)
+
+//shpRecord is the content of a polygon record of the rings
+func shpRecord(rings ...orb.Ring) []byte {
+	var content bytes.Buffer
+	binary.Write(&content, binary.LittleEndian, int32(5))
+	content.Write(make([]byte, 32)) // the bounding box is not read
+	points := 0
+	for _, ring := range rings {
+		points += len(ring)
+	}
+	binary.Write(&content, binary.LittleEndian, int32(len(rings)))
+	binary.Write(&content, binary.LittleEndian, int32(points))
+	start := 0
+	for _, ring := range rings {
+		binary.Write(&content, binary.LittleEndian, int32(start))
+		start += len(ring)
+	}
+	for _, ring := range rings {
+		for _, point := range ring {
+			binary.Write(&content, binary.LittleEndian, math.Float64bits(point[0]))
+			binary.Write(&content, binary.LittleEndian, math.Float64bits(point[1]))
+		}
+	}
+	return content.Bytes()
+}
+
+//shpFile frames the record contents with the file header and the record headers
+func shpFile(records ...[]byte) []byte {
+	var shp bytes.Buffer
+	header := make([]byte, 100)
+	binary.BigEndian.PutUint32(header[0:4], 9994)
+	binary.LittleEndian.PutUint32(header[28:32], 1000)
+	binary.LittleEndian.PutUint32(header[32:36], 5)
+	shp.Write(header)
+	for i, record := range records {
+		binary.Write(&shp, binary.BigEndian, int32(i+1))
+		binary.Write(&shp, binary.BigEndian, int32(len(record)/2))
+		shp.Write(record)
+	}
+	out := shp.Bytes()
+	binary.BigEndian.PutUint32(out[24:28], uint32(len(out)/2))
+	return out
+}
+
+//dbfFile is a dbase table of character fields, the values are written as given
+func dbfFile(names []string, lengths []int, records ...[]string) []byte {
+	recordLength := 1
+	for _, length := range lengths {
+		recordLength += length
+	}
+	var dbf bytes.Buffer
+	header := make([]byte, 32)
+	header[0] = 0x03
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(records)))
+	binary.LittleEndian.PutUint16(header[8:10], uint16(32+32*len(names)+1))
+	binary.LittleEndian.PutUint16(header[10:12], uint16(recordLength))
+	dbf.Write(header)
+	for i, name := range names {
+		descriptor := make([]byte, 32)
+		copy(descriptor, name)
+		descriptor[11] = 'C'
+		descriptor[16] = byte(lengths[i])
+		dbf.Write(descriptor)
+	}
+	dbf.WriteByte(0x0D)
+	for _, record := range records {
+		dbf.WriteByte(' ')
+		for i, value := range record {
+			padded := []byte(value + strings.Repeat(" ", lengths[i]))
+			dbf.Write(padded[:lengths[i]])
+		}
+	}
+	dbf.WriteByte(0x1A)
+	return dbf.Bytes()
+}
+
+//writeShapefile writes zones.shp, zones.dbf and zones.prj if prj is not empty, it returns the .shp path
+func writeShapefile(t *testing.T, shp []byte, dbf []byte, prj string) string {
+	base := filepath.Join(t.TempDir(), "zones")
+	files := map[string][]byte{".shp": shp, ".dbf": dbf}
+	if prj != "" {
+		files[".prj"] = []byte(prj)
+	}
+	for extension, content := range files {
+		if err := ioutil.WriteFile(base+extension, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base + ".shp"
+}
+
+func namesDBF(names ...string) []byte {
+	records := make([][]string, 0, len(names))
+	for _, name := range names {
+		records = append(records, []string{name, "5"})
+	}
+	return dbfFile([]string{"name", "pollution"}, []int{20, 1}, records...)
+}
+
+func TestShapefileRingOrder(t *testing.T) {
+	reversed := func(ring orb.Ring) orb.Ring {
+		r := append(orb.Ring{}, ring...)
+		r.Reverse()
+		return r
+	}
+	path := writeShapefile(t, shpFile(
+		shpRecord(outerSquare, holeSquare),
+		shpRecord(outerSquare, eastSquare),
+		//written without regard to the ring order, every ring counterclockwise
+		shpRecord(reversed(outerSquare), reversed(eastSquare)),
+	), namesDBF("with hole", "two parts", "counterclockwise"), "")
+
+	zones, _, err := readShapefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 3 {
+		t.Fatalf("%d zones, want 3", len(zones))
+	}
+	if outline := zones[0].Outline; len(outline) != 1 || len(outline[0]) != 2 {
+		t.Errorf("%s: outline %v, want one polygon with a hole", zones[0].Attributes["name"], outline)
+	}
+	if outline := zones[1].Outline; len(outline) != 2 || len(outline[0]) != 1 || len(outline[1]) != 1 {
+		t.Errorf("%s: outline %v, want two polygons without holes", zones[1].Attributes["name"], outline)
+	}
+	if outline := zones[2].Outline; len(outline) != 2 || len(outline[0]) != 1 || len(outline[1]) != 1 {
+		t.Errorf("%s: outline %v, want the counterclockwise rings as two polygons", zones[2].Attributes["name"], outline)
+	}
+}
+
+func TestShapefileHolesBelongToTheirOuterRing(t *testing.T) {
+	//the hole of the west square comes last, after the east square and its hole
+	path := writeShapefile(t, shpFile(shpRecord(outerSquare, eastSquare, eastHole, holeSquare)), namesDBF("holes"), "")
+
+	zones, _, err := readShapefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outline := zones[0].Outline
+	if len(outline) != 2 || len(outline[0]) != 2 || len(outline[1]) != 2 {
+		t.Fatalf("outline %v, want two polygons with a hole each", outline)
+	}
+	if outline[0][1][0] != holeSquare[0] || outline[1][1][0] != eastHole[0] {
+		t.Errorf("holes %v and %v are in the wrong polygons", outline[0][1], outline[1][1])
+	}
+}
+
+func TestShapefileProjection(t *testing.T) {
+	cases := []struct {
+		name string
+		prj  string
+		srid int
+	}{
+		{"epsg authority", `PROJCS["ETRS89 / UTM zone 33N",GEOGCS["ETRS89",DATUM["European_Terrestrial_Reference_System_1989",SPHEROID["GRS 1980",6378137,298.257222101,AUTHORITY["EPSG","7019"]],AUTHORITY["EPSG","6258"]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","4258"]],PROJECTION["Transverse_Mercator"],UNIT["metre",1],AUTHORITY["EPSG","25833"]]`, 25833},
+		{"esri name", `PROJCS["ETRS_1989_UTM_Zone_33N",GEOGCS["GCS_ETRS_1989",DATUM["D_ETRS_1989",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],UNIT["Meter",1.0]]`, 25833},
+		{"geographic", `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`, 4326},
+		{"unknown", `PROJCS["Somewhere_Local_Grid",GEOGCS["GCS_Unknown"]]`, 0},
+		{"no prj file", "", 0},
+	}
+	for _, c := range cases {
+		path := writeShapefile(t, shpFile(shpRecord(outerSquare)), namesDBF("zone"), c.prj)
+		_, srid, err := readShapefile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if srid != c.srid {
+			t.Errorf("%s: srid %d, want %d", c.name, srid, c.srid)
+		}
+	}
+}
+
+func TestDBFLatin1AndUTF8(t *testing.T) {
+	//Straße in latin-1 and Köpenick in utf-8
+	dbf := namesDBF("Stra\xdfe", "Köpenick")
+	records, err := readDBF(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0]["name"] != "Straße" || records[1]["name"] != "Köpenick" {
+		t.Errorf("records %v, want Straße and Köpenick", records)
+	}
+	if records[0]["pollution"] != "5" {
+		t.Errorf("pollution %q, want 5", records[0]["pollution"])
+	}
+}
+
+func TestTruncatedFilesAreErrors(t *testing.T) {
+	shortRecord := writeShapefile(t, shpFile([]byte{5, 0}), namesDBF("zone"), "")
+	if _, _, err := readShapefile(shortRecord); err == nil {
+		t.Error("record of 2 bytes read without error")
+	}
+
+	truncatedPolygon := writeShapefile(t, shpFile(shpRecord(outerSquare)[:60]), namesDBF("zone"), "")
+	if _, _, err := readShapefile(truncatedPolygon); err == nil {
+		t.Error("truncated polygon read without error")
+	}
+
+	//the fields claim more bytes than a record has
+	dbf := namesDBF("zone")
+	binary.LittleEndian.PutUint16(dbf[10:12], 5)
+	if _, err := readDBF(dbf); err == nil {
+		t.Error("dbase records shorter than their fields read without error")
+	}
+
+	if _, err := readDBF(dbf[:40]); err == nil {
+		t.Error("truncated dbase header read without error")
+	}
+}